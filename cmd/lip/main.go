@@ -10,6 +10,7 @@ import (
 	"github.com/blang/semver/v4"
 	"github.com/lippkg/lip/internal/cmd/cmdlip"
 	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/network"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/term"
 )
@@ -18,6 +19,18 @@ var defaultConfig context.Config = context.Config{
 	GitHubMirrorURL:  "https://github.com",
 	GoModuleProxyURL: "https://goproxy.io",
 	ProxyURL:         "",
+	NoProxy:          []string{},
+	ScriptPolicy:     "prompt",
+	HookTimeout:      "0s",
+	LockTimeout:      "0s",
+	HTTPCacheTTL:     "0s",
+	TrustedTeeth:     []string{},
+	Auth:             map[string]network.HostAuth{},
+	URLRewrites:      []network.RewriteRule{},
+	CABundle:         "",
+	ClientCert:       "",
+	ClientKey:        "",
+	Roots:            map[string]string{},
 }
 
 var lipVersion semver.Version = semver.MustParse("0.24.0")
@@ -64,16 +77,6 @@ func main() {
 
 	ctx := context.New(defaultConfig, lipVersion)
 
-	if err := ctx.CreateDirStructure(); err != nil {
-		log.Errorf("\n\tcannot create directory structure\n\t%v", err.Error())
-		return
-	}
-
-	if err := ctx.LoadOrCreateConfigFile(); err != nil {
-		log.Errorf("\n\tcannot load or create config file\n\t%v", err.Error())
-		return
-	}
-
 	if err := cmdlip.Run(ctx, os.Args); err != nil {
 		log.Errorf("\n\t%v", err.Error())
 		return
@@ -0,0 +1,353 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/lippkg/lip/internal/path"
+	"github.com/ulikunitz/xz"
+)
+
+// Format is the format of an archive, detected by its content.
+type Format int
+
+const (
+	// RawFormat is a single file that is not an archive, e.g. a bare binary.
+	RawFormat Format = iota
+	ZipFormat
+	TarFormat
+	TarGzFormat
+	TarXzFormat
+	TarZstFormat
+	TarBz2Format
+	// GzFormat, XzFormat, ZstFormat and Bz2Format are compressed single files.
+	GzFormat
+	XzFormat
+	ZstFormat
+	Bz2Format
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case RawFormat:
+		return "raw"
+	case ZipFormat:
+		return "zip"
+	case TarFormat:
+		return "tar"
+	case TarGzFormat:
+		return "tar.gz"
+	case TarXzFormat:
+		return "tar.xz"
+	case TarZstFormat:
+		return "tar.zst"
+	case TarBz2Format:
+		return "tar.bz2"
+	case GzFormat:
+		return "gz"
+	case XzFormat:
+		return "xz"
+	case ZstFormat:
+		return "zst"
+	case Bz2Format:
+		return "bz2"
+	}
+
+	return "unknown"
+}
+
+// compression is a compression format wrapping a tar archive or a single file.
+type compression struct {
+	magic        []byte
+	suffix       string
+	tarFormat    Format
+	singleFormat Format
+	newReader    func(io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = []compression{
+	{
+		magic:        []byte{0x1f, 0x8b},
+		suffix:       ".gz",
+		tarFormat:    TarGzFormat,
+		singleFormat: GzFormat,
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		magic:        []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		suffix:       ".xz",
+		tarFormat:    TarXzFormat,
+		singleFormat: XzFormat,
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			xzr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(xzr), nil
+		},
+	},
+	{
+		magic:        []byte{0x28, 0xb5, 0x2f, 0xfd},
+		suffix:       ".zst",
+		tarFormat:    TarZstFormat,
+		singleFormat: ZstFormat,
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+	{
+		magic:        []byte{'B', 'Z', 'h'},
+		suffix:       ".bz2",
+		tarFormat:    TarBz2Format,
+		singleFormat: Bz2Format,
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+}
+
+// unsupportedMagics are magic bytes of archive formats that are not supported.
+var unsupportedMagics = map[string][]byte{
+	"rar": []byte("Rar!\x1a\x07"),
+	"7z":  {'7', 'z', 0xbc, 0xaf, 0x27, 0x1c},
+}
+
+const sniffLen = 512
+
+// DetectFormat detects the format of an archive file by its magic bytes. Files
+// of unknown content are treated as raw files, while known but unsupported
+// archive formats are reported as errors.
+func DetectFormat(filePath path.Path) (Format, error) {
+	file, err := os.Open(filePath.LocalString())
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %v\n\t%w", filePath.LocalString(), err)
+	}
+	defer file.Close()
+
+	header, err := readHeader(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	if bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")) {
+		return ZipFormat, nil
+	}
+
+	if isTarHeader(header) {
+		return TarFormat, nil
+	}
+
+	for name, magic := range unsupportedMagics {
+		if bytes.HasPrefix(header, magic) {
+			return 0, fmt.Errorf("unsupported archive format %v: %v", name, filePath.LocalString())
+		}
+	}
+
+	for _, c := range compressions {
+		if !bytes.HasPrefix(header, c.magic) {
+			continue
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("failed to seek %v\n\t%w", filePath.LocalString(), err)
+		}
+
+		r, err := c.newReader(file)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open %v reader for %v\n\t%w", strings.TrimPrefix(c.suffix, "."),
+				filePath.LocalString(), err)
+		}
+		defer r.Close()
+
+		innerHeader, err := readHeader(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decompress %v\n\t%w", filePath.LocalString(), err)
+		}
+
+		if isTarHeader(innerHeader) {
+			return c.tarFormat, nil
+		}
+
+		return c.singleFormat, nil
+	}
+
+	return RawFormat, nil
+}
+
+// RawFileName returns the name of the only file of a raw or compressed single
+// file asset downloaded from assetURL, i.e. the base name of the URL path.
+func RawFileName(assetURL *url.URL) string {
+	return gopath.Base(assetURL.Path)
+}
+
+// ArchiveReader reads the files of an archive. Only regular files are included.
+type ArchiveReader interface {
+	// Close closes the archive.
+	Close() error
+	// Extract extracts files in one pass. dests maps the path string of each file
+	// to extract to its destinations. Parent directories of destinations are
+	// created. It fails if any file is not in the archive.
+	Extract(dests map[string][]path.Path) error
+	// FilePaths returns the paths of all files in the archive.
+	FilePaths() []path.Path
+	// ReadFile reads the content of a file in the archive.
+	ReadFile(filePath path.Path) ([]byte, error)
+	// Walk calls fn for each file in the archive with its content.
+	Walk(fn func(filePath path.Path, r io.Reader) error) error
+}
+
+// Open opens an archive and indexes its files. rawFileName is the name of the
+// only file when the archive is a raw or compressed single file.
+func Open(filePath path.Path, rawFileName string) (ArchiveReader, error) {
+	format, err := DetectFormat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case ZipFormat:
+		return openZipReader(filePath)
+
+	case RawFormat:
+		return openSingleFileReader(filePath, nil, rawFileName)
+
+	case TarFormat:
+		return openTarReader(filePath, nil)
+	}
+
+	for i := range compressions {
+		c := &compressions[i]
+
+		switch format {
+		case c.tarFormat:
+			return openTarReader(filePath, c)
+
+		case c.singleFormat:
+			return openSingleFileReader(filePath, c, strings.TrimSuffix(rawFileName, c.suffix))
+		}
+	}
+
+	panic("unreachable")
+}
+
+// openStream opens a file and decompresses it with c. If c is nil, the file is
+// read as is.
+func openStream(filePath path.Path, c *compression) (io.ReadCloser, error) {
+	file, err := os.Open(filePath.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	if c == nil {
+		return file, nil
+	}
+
+	r, err := c.newReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open %v reader for %v\n\t%w", strings.TrimPrefix(c.suffix, "."),
+			filePath.LocalString(), err)
+	}
+
+	return &stream{r: r, file: file}, nil
+}
+
+// stream is a decompressed file.
+type stream struct {
+	r    io.ReadCloser
+	file *os.File
+}
+
+func (s *stream) Close() error {
+	s.r.Close()
+	return s.file.Close()
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// checkExtractSources checks that all files to extract are in the archive.
+func checkExtractSources(filePaths []path.Path, dests map[string][]path.Path) error {
+	filePathSet := make(map[string]bool, len(filePaths))
+	for _, filePath := range filePaths {
+		filePathSet[filePath.String()] = true
+	}
+
+	for filePathStr := range dests {
+		if !filePathSet[filePathStr] {
+			return fmt.Errorf("file %v not found in archive", filePathStr)
+		}
+	}
+
+	return nil
+}
+
+// copyToFiles copies the content of r to each destination file.
+func copyToFiles(r io.Reader, dests []path.Path) error {
+	writers := make([]io.Writer, 0, len(dests))
+
+	for _, dest := range dests {
+		if err := os.MkdirAll(filepath.Dir(dest.LocalString()), 0755); err != nil {
+			return fmt.Errorf("failed to create directory of %v\n\t%w", dest.LocalString(), err)
+		}
+
+		fw, err := os.Create(dest.LocalString())
+		if err != nil {
+			return fmt.Errorf("failed to create %v\n\t%w", dest.LocalString(), err)
+		}
+		defer fw.Close()
+
+		writers = append(writers, fw)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return fmt.Errorf("failed to write file\n\t%w", err)
+	}
+
+	return nil
+}
+
+// parseEntryPath parses the path of an archive entry. Absolute paths are rejected.
+func parseEntryPath(name string) (path.Path, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return path.Path{}, fmt.Errorf("invalid file path %v in archive", name)
+	}
+
+	entryPath, err := path.Parse(name)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to parse file path %v in archive\n\t%w", name, err)
+	}
+
+	return entryPath, nil
+}
+
+func readHeader(r io.Reader) ([]byte, error) {
+	header, err := bufio.NewReaderSize(r, sniffLen).Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+func isTarHeader(header []byte) bool {
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
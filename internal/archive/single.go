@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/lippkg/lip/internal/path"
+)
+
+// singleFileReader reads a raw or compressed single file as an archive
+// containing only that file.
+type singleFileReader struct {
+	filePath    path.Path
+	compression *compression
+	entryPath   path.Path
+}
+
+func openSingleFileReader(filePath path.Path, c *compression, name string) (*singleFileReader, error) {
+	entryPath, err := parseEntryPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &singleFileReader{
+		filePath:    filePath,
+		compression: c,
+		entryPath:   entryPath,
+	}, nil
+}
+
+func (r *singleFileReader) Close() error {
+	return nil
+}
+
+func (r *singleFileReader) Extract(dests map[string][]path.Path) error {
+	if err := checkExtractSources(r.filePaths(), dests); err != nil {
+		return err
+	}
+
+	fileDests, ok := dests[r.entryPath.String()]
+	if !ok {
+		return nil
+	}
+
+	return r.Walk(func(entryPath path.Path, entryReader io.Reader) error {
+		if err := copyToFiles(entryReader, fileDests); err != nil {
+			return fmt.Errorf("failed to extract %v\n\t%w", entryPath.String(), err)
+		}
+
+		return nil
+	})
+}
+
+func (r *singleFileReader) FilePaths() []path.Path {
+	return r.filePaths()
+}
+
+func (r *singleFileReader) ReadFile(filePath path.Path) ([]byte, error) {
+	if !filePath.Equal(r.entryPath) {
+		return nil, fmt.Errorf("file %v not found in archive", filePath.String())
+	}
+
+	stream, err := openStream(r.filePath, r.compression)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return io.ReadAll(stream)
+}
+
+func (r *singleFileReader) Walk(fn func(filePath path.Path, r io.Reader) error) error {
+	stream, err := openStream(r.filePath, r.compression)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return fn(r.entryPath, stream)
+}
+
+func (r *singleFileReader) filePaths() []path.Path {
+	return []path.Path{r.entryPath}
+}
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+
+	"github.com/lippkg/lip/internal/path"
+)
+
+// tarReader reads the files of a tar archive, optionally compressed. As tar
+// archives cannot be read randomly, each operation reads the archive once.
+type tarReader struct {
+	filePath    path.Path
+	compression *compression
+	filePaths   []path.Path
+}
+
+func openTarReader(filePath path.Path, c *compression) (*tarReader, error) {
+	r := &tarReader{
+		filePath:    filePath,
+		compression: c,
+	}
+
+	filePaths := make([]path.Path, 0)
+	err := r.Walk(func(entryPath path.Path, _ io.Reader) error {
+		filePaths = append(filePaths, entryPath)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.filePaths = filePaths
+
+	return r, nil
+}
+
+func (r *tarReader) Close() error {
+	return nil
+}
+
+func (r *tarReader) Extract(dests map[string][]path.Path) error {
+	if err := checkExtractSources(r.filePaths, dests); err != nil {
+		return err
+	}
+
+	return r.Walk(func(entryPath path.Path, entryReader io.Reader) error {
+		fileDests, ok := dests[entryPath.String()]
+		if !ok {
+			return nil
+		}
+
+		if err := copyToFiles(entryReader, fileDests); err != nil {
+			return fmt.Errorf("failed to extract %v\n\t%w", entryPath.String(), err)
+		}
+
+		return nil
+	})
+}
+
+func (r *tarReader) FilePaths() []path.Path {
+	return r.filePaths
+}
+
+func (r *tarReader) ReadFile(filePath path.Path) ([]byte, error) {
+	var content []byte
+
+	err := r.Walk(func(entryPath path.Path, entryReader io.Reader) error {
+		if content != nil || !entryPath.Equal(filePath) {
+			return nil
+		}
+
+		var err error
+		content, err = io.ReadAll(entryReader)
+		if err != nil {
+			return fmt.Errorf("failed to read %v\n\t%w", filePath.String(), err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if content == nil {
+		return nil, fmt.Errorf("file %v not found in archive", filePath.String())
+	}
+
+	return content, nil
+}
+
+func (r *tarReader) Walk(fn func(filePath path.Path, r io.Reader) error) error {
+	stream, err := openStream(r.filePath, r.compression)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	tarR := tar.NewReader(stream)
+
+	for {
+		header, err := tarR.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read tar %v\n\t%w", r.filePath.LocalString(), err)
+		}
+
+		// Skip directories, links and other special files.
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entryPath, err := parseEntryPath(header.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entryPath, tarR); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lippkg/lip/internal/path"
+)
+
+// zipReader reads the files of a zip archive. Entries are indexed once when the
+// archive is opened.
+type zipReader struct {
+	r         *zip.ReadCloser
+	filePaths []path.Path
+	files     map[string]*zip.File
+}
+
+// OpenZip opens a zip archive and indexes its files, whatever its content looks
+// like. Tooth archives are always zip archives.
+func OpenZip(filePath path.Path) (ArchiveReader, error) {
+	return openZipReader(filePath)
+}
+
+func openZipReader(filePath path.Path) (*zipReader, error) {
+	r, err := zip.OpenReader(filePath.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip reader %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	filePaths := make([]path.Path, 0, len(r.File))
+	files := make(map[string]*zip.File, len(r.File))
+
+	for _, file := range r.File {
+		// Skip directories.
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		entryPath, err := parseEntryPath(file.Name)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+
+		filePaths = append(filePaths, entryPath)
+		files[entryPath.String()] = file
+	}
+
+	return &zipReader{
+		r:         r,
+		filePaths: filePaths,
+		files:     files,
+	}, nil
+}
+
+func (r *zipReader) Close() error {
+	return r.r.Close()
+}
+
+func (r *zipReader) Extract(dests map[string][]path.Path) error {
+	if err := checkExtractSources(r.filePaths, dests); err != nil {
+		return err
+	}
+
+	for filePathStr, fileDests := range dests {
+		rc, err := r.files[filePathStr].Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %v in zip\n\t%w", filePathStr, err)
+		}
+
+		err = copyToFiles(rc, fileDests)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to extract %v\n\t%w", filePathStr, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *zipReader) FilePaths() []path.Path {
+	return r.filePaths
+}
+
+func (r *zipReader) ReadFile(filePath path.Path) ([]byte, error) {
+	file, ok := r.files[filePath.String()]
+	if !ok {
+		return nil, fmt.Errorf("file %v not found in zip", filePath.String())
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %v in zip\n\t%w", filePath.String(), err)
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (r *zipReader) Walk(fn func(filePath path.Path, r io.Reader) error) error {
+	for _, filePath := range r.filePaths {
+		rc, err := r.files[filePath.String()].Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %v in zip\n\t%w", filePath.String(), err)
+		}
+
+		err = fn(filePath, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,510 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/lock"
+	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+)
+
+const indexFileName = "index.json"
+const indexFormatVersion = 1
+
+// Entry describes a cached download.
+type Entry struct {
+	URL string `json:"url"`
+	// Blob is the digest of the content in the form of sha256:<hex>.
+	Blob      string    `json:"blob"`
+	Size      int64     `json:"size"`
+	FetchedAt time.Time `json:"fetched_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ETag      string    `json:"etag,omitempty"`
+	// Source is the URL the file was downloaded from after rewriting. It is empty
+	// if the file was downloaded from URL or the source is unknown.
+	Source string `json:"source,omitempty"`
+	// Tooth and Version identify the tooth the file was downloaded for. They are
+	// empty if unknown.
+	Tooth   string `json:"tooth,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Cache is a content-addressed store of downloaded files. Contents are stored as
+// blobs named by their SHA-256 digests, and an index maps URLs to blobs.
+type Cache struct {
+	dir         path.Path
+	entries     map[string]Entry
+	lockTimeout time.Duration
+}
+
+type rawIndex struct {
+	Version int              `json:"version"`
+	Entries map[string]Entry `json:"entries"`
+}
+
+// Open opens the cache. Files cached by older versions of lip, which are named
+// by escaped URLs, are migrated into the store.
+func Open(ctx *context.Context) (*Cache, error) {
+	dir, err := ctx.CacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache directory\n\t%w", err)
+	}
+
+	lockTimeout, err := ctx.LockTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lock timeout\n\t%w", err)
+	}
+
+	c := &Cache{
+		dir:         dir,
+		entries:     make(map[string]Entry),
+		lockTimeout: lockTimeout,
+	}
+
+	if err := os.MkdirAll(c.blobDir().LocalString(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory\n\t%w", err)
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	legacyFiles, err := c.legacyFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate cache\n\t%w", err)
+	}
+
+	// The index lock is taken only if there is something to migrate.
+	if len(legacyFiles) > 0 {
+		if err := c.update(c.migrate); err != nil {
+			return nil, fmt.Errorf("failed to migrate cache\n\t%w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Add moves a downloaded file into the store and records it in the index. source
+// is the URL the file was downloaded from after rewriting. toothRepoPath and
+// version identify the tooth the file was downloaded for, and may be empty. It
+// returns the path of the blob.
+func (c *Cache) Add(u *url.URL, filePath path.Path, etag string, source *url.URL, toothRepoPath string,
+	version string) (path.Path, error) {
+	var entry Entry
+
+	err := c.update(func() (bool, error) {
+		var err error
+		entry, err = c.addBlob(u.String(), filePath, time.Now())
+		if err != nil {
+			return false, err
+		}
+
+		entry.ETag = etag
+		if source.String() != u.String() {
+			entry.Source = source.String()
+		}
+		entry.Tooth = toothRepoPath
+		entry.Version = version
+		c.entries[u.String()] = entry
+
+		return true, nil
+	})
+	if err != nil {
+		return path.Path{}, err
+	}
+
+	return c.BlobPath(entry.Blob)
+}
+
+// BlobPath returns the path of a blob in the form of sha256:<hex>.
+func (c *Cache) BlobPath(blob string) (path.Path, error) {
+	hexDigest := strings.TrimPrefix(blob, "sha256:")
+	if hexDigest == blob || len(hexDigest) != sha256.Size*2 {
+		return path.Path{}, fmt.Errorf("invalid blob %v", blob)
+	}
+
+	if _, err := hex.DecodeString(hexDigest); err != nil {
+		return path.Path{}, fmt.Errorf("invalid blob %v", blob)
+	}
+
+	return c.blobDir().Join(path.MustParse(hexDigest)), nil
+}
+
+// Dir returns the cache directory.
+func (c *Cache) Dir() path.Path {
+	return c.dir
+}
+
+// Entry returns the entry of a URL. The second return value is false if the URL
+// is not cached.
+func (c *Cache) Entry(u *url.URL) (Entry, bool) {
+	entry, ok := c.entries[u.String()]
+	return entry, ok
+}
+
+// Entries returns all entries sorted by URL.
+func (c *Cache) Entries() []Entry {
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].URL < entries[j].URL
+	})
+
+	return entries
+}
+
+// Lookup returns the path of the blob cached for a URL and marks the entry as
+// used. The second return value is false if the URL is not cached. The index is
+// written only if the URL is cached.
+func (c *Cache) Lookup(u *url.URL) (path.Path, bool, error) {
+	// The index is replaced atomically, so it can be read without the lock.
+	if err := c.load(); err != nil {
+		return path.Path{}, false, err
+	}
+
+	entry, ok := c.entries[u.String()]
+	if !ok {
+		return path.Path{}, false, nil
+	}
+
+	blobPath, err := c.BlobPath(entry.Blob)
+	if err != nil {
+		return path.Path{}, false, err
+	}
+
+	if _, err := os.Stat(blobPath.LocalString()); os.IsNotExist(err) {
+		return path.Path{}, false, nil
+	} else if err != nil {
+		return path.Path{}, false, fmt.Errorf("failed to check blob %v\n\t%w", blobPath.LocalString(), err)
+	}
+
+	err = c.update(func() (bool, error) {
+		// Another process may have replaced or removed the entry meanwhile.
+		entry, ok := c.entries[u.String()]
+		if !ok {
+			return false, nil
+		}
+
+		entry.LastUsed = time.Now()
+		c.entries[u.String()] = entry
+
+		return true, nil
+	})
+	if err != nil {
+		return path.Path{}, false, err
+	}
+
+	return blobPath, true, nil
+}
+
+// LockEntry acquires the lock of the entry of a URL, so that concurrent downloads
+// of the same URL wait for each other. The lock must be released after the
+// download.
+func (c *Cache) LockEntry(u *url.URL) (*lock.Lock, error) {
+	urlHash := sha256.Sum256([]byte(u.String()))
+	lockPath := c.dir.Join(path.MustParse("locks/" + hex.EncodeToString(urlHash[:]) + ".lock"))
+
+	entryLock, err := lock.Acquire(lockPath, c.lockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock cache entry of %v\n\t%w", u, err)
+	}
+
+	return entryLock, nil
+}
+
+// Remove removes entries by URL. Blobs no longer referenced by any entry are
+// deleted.
+func (c *Cache) Remove(urlStrs ...string) error {
+	return c.update(func() (bool, error) {
+		for _, urlStr := range urlStrs {
+			delete(c.entries, urlStr)
+		}
+
+		if err := c.removeUnreferencedBlobs(); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	})
+}
+
+// TotalSize returns the total size of all blobs in bytes.
+func (c *Cache) TotalSize() (int64, error) {
+	dirEntries, err := os.ReadDir(c.blobDir().LocalString())
+	if err != nil {
+		return 0, fmt.Errorf("failed to read blob directory\n\t%w", err)
+	}
+
+	var totalSize int64
+	for _, dirEntry := range dirEntries {
+		fileInfo, err := dirEntry.Info()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get file info of blob %v\n\t%w", dirEntry.Name(), err)
+		}
+
+		totalSize += fileInfo.Size()
+	}
+
+	return totalSize, nil
+}
+
+// TempFilePath creates an empty temporary file in the cache directory to
+// download into, and returns its path.
+func (c *Cache) TempFilePath() (path.Path, error) {
+	file, err := os.CreateTemp(c.dir.LocalString(), "download-*.tmp")
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to create temporary file\n\t%w", err)
+	}
+	defer file.Close()
+
+	return path.Parse(file.Name())
+}
+
+// ---------------------------------------------------------------------
+
+// addBlob hashes a file and moves it into the store. If the blob already
+// exists, the file is removed instead.
+func (c *Cache) addBlob(urlStr string, filePath path.Path, fetchedAt time.Time) (Entry, error) {
+	file, err := os.Open(filePath.LocalString())
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to open %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to hash %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	blob := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	blobPath, err := c.BlobPath(blob)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if _, err := os.Stat(blobPath.LocalString()); err == nil {
+		// Identical content is stored only once.
+		if err := os.Remove(filePath.LocalString()); err != nil {
+			return Entry{}, fmt.Errorf("failed to remove %v\n\t%w", filePath.LocalString(), err)
+		}
+	} else if err := os.Rename(filePath.LocalString(), blobPath.LocalString()); err != nil {
+		return Entry{}, fmt.Errorf("failed to move %v to %v\n\t%w", filePath.LocalString(),
+			blobPath.LocalString(), err)
+	}
+
+	return Entry{
+		URL:       urlStr,
+		Blob:      blob,
+		Size:      size,
+		FetchedAt: fetchedAt,
+		LastUsed:  fetchedAt,
+	}, nil
+}
+
+// removeUnreferencedBlobs deletes blobs not referenced by any entry.
+func (c *Cache) removeUnreferencedBlobs() error {
+	referencedBlobs := make(map[string]bool)
+	for _, entry := range c.entries {
+		referencedBlobs[strings.TrimPrefix(entry.Blob, "sha256:")] = true
+	}
+
+	dirEntries, err := os.ReadDir(c.blobDir().LocalString())
+	if err != nil {
+		return fmt.Errorf("failed to read blob directory\n\t%w", err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		if referencedBlobs[dirEntry.Name()] {
+			continue
+		}
+
+		blobPath := c.blobDir().Join(path.MustParse(dirEntry.Name()))
+		if err := os.Remove(blobPath.LocalString()); err != nil {
+			return fmt.Errorf("failed to remove blob %v\n\t%w", blobPath.LocalString(), err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) blobDir() path.Path {
+	return c.dir.Join(path.MustParse("blobs/sha256"))
+}
+
+func (c *Cache) indexPath() path.Path {
+	return c.dir.Join(path.MustParse(indexFileName))
+}
+
+// legacyFiles lists the files in the cache directory that may have been cached
+// by older versions of lip.
+func (c *Cache) legacyFiles() ([]os.DirEntry, error) {
+	dirEntries, err := os.ReadDir(c.dir.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory\n\t%w", err)
+	}
+
+	legacyFiles := make([]os.DirEntry, 0)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || name == indexFileName || strings.HasSuffix(name, ".tmp") ||
+			strings.HasSuffix(name, ".lock") {
+			continue
+		}
+
+		legacyFiles = append(legacyFiles, dirEntry)
+	}
+
+	return legacyFiles, nil
+}
+
+// migrate moves files named by escaped URLs in the cache directory into the store.
+func (c *Cache) migrate() (bool, error) {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "cache",
+		"method":  "migrate",
+	})
+
+	legacyFiles, err := c.legacyFiles()
+	if err != nil {
+		return false, err
+	}
+
+	isChanged := false
+	for _, dirEntry := range legacyFiles {
+		name := dirEntry.Name()
+
+		urlStr, err := url.QueryUnescape(name)
+		if err != nil {
+			debugLogger.Debugf("Skipped %v because it is not named by a URL", name)
+			continue
+		}
+
+		filePath, err := path.Parse(name)
+		if err != nil {
+			debugLogger.Debugf("Skipped %v because its name is invalid", name)
+			continue
+		}
+		filePath = c.dir.Join(filePath)
+
+		u, err := url.Parse(urlStr)
+		if err != nil || !u.IsAbs() {
+			debugLogger.Debugf("Skipped %v because it is not named by a URL", name)
+			continue
+		}
+
+		fileInfo, err := dirEntry.Info()
+		if err != nil {
+			return isChanged, fmt.Errorf("failed to get file info of %v\n\t%w", filePath.LocalString(), err)
+		}
+
+		entry, err := c.addBlob(urlStr, filePath, fileInfo.ModTime())
+		if err != nil {
+			return isChanged, err
+		}
+
+		c.entries[urlStr] = entry
+		isChanged = true
+
+		debugLogger.Debugf("Migrated %v to %v", name, entry.Blob)
+	}
+
+	return isChanged, nil
+}
+
+// update reloads the index and applies fn while holding the index lock, so that
+// concurrent processes do not lose each other's changes. fn returns whether it
+// changed the entries, and the index is saved only if so.
+func (c *Cache) update(fn func() (bool, error)) error {
+	indexLock, err := lock.Acquire(c.dir.Join(path.MustParse("index.lock")), c.lockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock cache index\n\t%w", err)
+	}
+	defer indexLock.Release()
+
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	isChanged, err := fn()
+	if isChanged {
+		// Blobs already moved into the store are kept in the index even if fn
+		// failed halfway.
+		if err := c.save(); err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// load reads the index.
+func (c *Cache) load() error {
+	c.entries = make(map[string]Entry)
+
+	indexBytes, err := os.ReadFile(c.indexPath().LocalString())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read cache index %v\n\t%w", c.indexPath().LocalString(), err)
+	}
+
+	var index rawIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return fmt.Errorf("failed to parse cache index %v\n\t%w", c.indexPath().LocalString(), err)
+	}
+
+	if index.Version != indexFormatVersion {
+		return fmt.Errorf("unsupported cache index version %v", index.Version)
+	}
+
+	if index.Entries != nil {
+		c.entries = index.Entries
+	}
+
+	return nil
+}
+
+// save writes the index atomically.
+func (c *Cache) save() error {
+	indexBytes, err := json.MarshalIndent(rawIndex{
+		Version: indexFormatVersion,
+		Entries: c.entries,
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache index\n\t%w", err)
+	}
+
+	tempFile, err := os.CreateTemp(c.dir.LocalString(), "index-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file\n\t%w", err)
+	}
+
+	_, err = tempFile.Write(indexBytes)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write cache index\n\t%w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), c.indexPath().LocalString()); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to save cache index\n\t%w", err)
+	}
+
+	return nil
+}
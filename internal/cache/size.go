@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TiB", 1 << 40},
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"TB", 1000 * 1000 * 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"MB", 1000 * 1000},
+	{"KB", 1000},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// FormatSize formats a size in bytes for humans, e.g. 1.5 MiB.
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits[:4] {
+		if size >= unit.size {
+			return fmt.Sprintf("%.1f %v", float64(size)/float64(unit.size), unit.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%v B", size)
+}
+
+// ParseSize parses a size like 500MB, 2GiB, 1G or 1024.
+func ParseSize(sizeStr string) (int64, error) {
+	trimmed := strings.TrimSpace(sizeStr)
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(trimmed), strings.ToUpper(unit.suffix)) {
+			trimmed = strings.TrimSpace(trimmed[:len(trimmed)-len(unit.suffix)])
+			multiplier = unit.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %v", sizeStr)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
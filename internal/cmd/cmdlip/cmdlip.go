@@ -5,11 +5,15 @@ import (
 	"os"
 
 	nested "github.com/antonfisher/nested-logrus-formatter"
+	"github.com/lippkg/lip/internal/cmd/cmdlipbackup"
 	"github.com/lippkg/lip/internal/cmd/cmdlipcache"
 	"github.com/lippkg/lip/internal/cmd/cmdlipconfig"
+	"github.com/lippkg/lip/internal/cmd/cmdlipexec"
 	"github.com/lippkg/lip/internal/cmd/cmdlipfreeze"
+	"github.com/lippkg/lip/internal/cmd/cmdliphistory"
 	"github.com/lippkg/lip/internal/cmd/cmdlipinstall"
 	"github.com/lippkg/lip/internal/cmd/cmdliplist"
+	"github.com/lippkg/lip/internal/cmd/cmdliprollback"
 	"github.com/lippkg/lip/internal/cmd/cmdlipshow"
 	"github.com/lippkg/lip/internal/cmd/cmdliptooth"
 	"github.com/lippkg/lip/internal/cmd/cmdlipuninstall"
@@ -85,6 +89,8 @@ Options:{{template "visibleFlagTemplate" .}}{{end}}
 		Name:    "lip",
 		Usage:   "A general package installer",
 		Version: ctx.LipVersion().String(),
+		// Config overrides may contain commas, e.g. in lists.
+		DisableSliceFlagSeparator: true,
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
 				Name:               "verbose",
@@ -103,6 +109,20 @@ Options:{{template "visibleFlagTemplate" .}}{{end}}
 				Usage:              "disable color output",
 				DisableDefaultText: true,
 			},
+			&cli.BoolFlag{
+				Name:               "non-interactive",
+				Usage:              "never ask for input, failing when input is needed. Also set by LIP_NONINTERACTIVE",
+				DisableDefaultText: true,
+			},
+			&cli.StringFlag{
+				Name:    "workspace",
+				Aliases: []string{"C"},
+				Usage:   "use `DIR` as the workspace instead of the nearest directory containing .lip",
+			},
+			&cli.StringSliceFlag{
+				Name:  "config",
+				Usage: "override a config value for this invocation, e.g. --config proxy_url=http://proxy:8080",
+			},
 		},
 		Before: func(cCtx *cli.Context) error {
 			if cCtx.Bool("no-color") {
@@ -116,19 +136,45 @@ Options:{{template "visibleFlagTemplate" .}}{{end}}
 				log.SetLevel(log.InfoLevel)
 			}
 
+			ctx.SetNonInteractive(cCtx.Bool("non-interactive"))
+
 			if cCtx.Bool("verbose") && cCtx.Bool("quiet") {
 				return fmt.Errorf("verbose and quiet flags are mutually exclusive")
 			}
+
+			// The workspace must be known before its .lip directory and config file
+			// are touched.
+			if cCtx.IsSet("workspace") {
+				if err := ctx.SetWorkspaceDir(cCtx.String("workspace")); err != nil {
+					return err
+				}
+			}
+
+			if err := ctx.CreateDirStructure(); err != nil {
+				return fmt.Errorf("cannot create directory structure\n\t%w", err)
+			}
+
+			if err := ctx.LoadOrCreateConfigFile(); err != nil {
+				return fmt.Errorf("cannot load or create config file\n\t%w", err)
+			}
+
+			if err := ctx.ApplyConfigOverrides(cCtx.StringSlice("config")); err != nil {
+				return err
+			}
 			return nil
 		},
 		Commands: []*cli.Command{
+			cmdlipbackup.Command(ctx),
 			cmdlipcache.Command(ctx),
 			cmdlipconfig.Command(ctx),
 			cmdlipinstall.Command(ctx),
 			cmdlipuninstall.Command(ctx),
 			cmdliplist.Command(ctx),
 			cmdlipshow.Command(ctx),
+			cmdlipexec.Command(ctx),
 			cmdlipfreeze.Command(ctx),
+			cmdliphistory.Command(ctx),
+			cmdliprollback.Command(ctx),
 			cmdliptooth.Command(ctx),
 		},
 		CommandNotFound: func(cCtx *cli.Context, command string) {
@@ -0,0 +1,29 @@
+package cmdlipbackup
+
+import (
+	"fmt"
+
+	"github.com/lippkg/lip/internal/cmd/cmdlipbackuplist"
+	"github.com/lippkg/lip/internal/cmd/cmdlipbackuprestore"
+	"github.com/lippkg/lip/internal/context"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "inspect and restore files moved aside on install",
+		Subcommands: []*cli.Command{
+			cmdlipbackuplist.Command(ctx),
+			cmdlipbackuprestore.Command(ctx),
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() >= 1 {
+				return fmt.Errorf("unknown command: lip %v %v", cCtx.Command.Name, cCtx.Args().First())
+			}
+			return fmt.Errorf(
+				"no command specified. See 'lip %v --help' for more information", cCtx.Command.Name)
+		},
+	}
+}
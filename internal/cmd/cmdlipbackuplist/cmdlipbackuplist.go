@@ -0,0 +1,72 @@
+package cmdlipbackuplist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/install"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "list",
+		Usage:       "list backups",
+		Description: "List backups of existing destinations moved aside on install, with the teeth that replaced them.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "json",
+				Usage:              "output in JSON format",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 0 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			backups, err := install.ListBackups(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list backups\n\t%w", err)
+			}
+
+			if cCtx.Bool("json") {
+				jsonBytes, err := json.Marshal(backups)
+				if err != nil {
+					return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+				}
+
+				fmt.Print(string(jsonBytes))
+				return nil
+			}
+
+			tableString := &strings.Builder{}
+			table := tablewriter.NewWriter(tableString)
+			table.SetHeader([]string{
+				"ID", "Time", "Path", "Tooth",
+			})
+
+			for _, backup := range backups {
+				for _, entry := range backup.Entries {
+					table.Append([]string{
+						backup.ID,
+						backup.CreatedAt.Local().Format(time.RFC3339),
+						entry.Path,
+						entry.Tooth,
+					})
+				}
+			}
+
+			table.Render()
+
+			fmt.Print(tableString.String())
+
+			return nil
+		},
+	}
+}
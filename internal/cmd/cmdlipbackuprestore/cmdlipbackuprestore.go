@@ -0,0 +1,68 @@
+package cmdlipbackuprestore
+
+import (
+	"fmt"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/install"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/urfave/cli/v2"
+)
+
+const descriptionText = `
+Move the files in a backup listed by "lip backup list" back into the workspace
+and delete the backup. Without an ID, the newest backup is restored.
+
+Destinations placed by a tooth since the backup are usually still there, so
+uninstall the tooth first, or pass --overwrite to replace them.
+`
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "restore",
+		Usage:       "restore a backup",
+		Description: descriptionText,
+		ArgsUsage:   "[backup ID]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "overwrite",
+				Usage:              "replace destinations that exist",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() > 1 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			workspaceLock, err := ctx.LockWorkspace()
+			if err != nil {
+				return err
+			}
+			defer workspaceLock.Release()
+
+			id := cCtx.Args().First()
+			if id == "" {
+				backups, err := install.ListBackups(ctx)
+				if err != nil {
+					return fmt.Errorf("failed to list backups\n\t%w", err)
+				}
+
+				if len(backups) == 0 {
+					return fmt.Errorf("no backup to restore")
+				}
+
+				id = backups[len(backups)-1].ID
+			}
+
+			if err := install.RestoreBackup(ctx, id, cCtx.Bool("overwrite")); err != nil {
+				return fmt.Errorf("failed to restore backup %v\n\t%w", id, err)
+			}
+
+			log.Info("Done.")
+
+			return nil
+		},
+	}
+}
@@ -3,7 +3,11 @@ package cmdlipcache
 import (
 	"fmt"
 
+	"github.com/lippkg/lip/internal/cmd/cmdlipcacheinfo"
+	"github.com/lippkg/lip/internal/cmd/cmdlipcachelist"
+	"github.com/lippkg/lip/internal/cmd/cmdlipcacheprune"
 	"github.com/lippkg/lip/internal/cmd/cmdlipcachepurge"
+	"github.com/lippkg/lip/internal/cmd/cmdlipcacheremove"
 	"github.com/lippkg/lip/internal/context"
 
 	"github.com/urfave/cli/v2"
@@ -14,7 +18,11 @@ func Command(ctx *context.Context) *cli.Command {
 		Name:  "cache",
 		Usage: "inspect and manage lip's cache",
 		Subcommands: []*cli.Command{
+			cmdlipcacheinfo.Command(ctx),
+			cmdlipcachelist.Command(ctx),
+			cmdlipcacheprune.Command(ctx),
 			cmdlipcachepurge.Command(ctx),
+			cmdlipcacheremove.Command(ctx),
 		},
 		Action: func(cCtx *cli.Context) error {
 			if cCtx.NArg() >= 1 {
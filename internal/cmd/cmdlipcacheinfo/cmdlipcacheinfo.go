@@ -0,0 +1,74 @@
+package cmdlipcacheinfo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lippkg/lip/internal/cache"
+	"github.com/lippkg/lip/internal/context"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "info",
+		Usage:       "show information about the cache",
+		Description: "Show the location, number of entries and total size of the cache.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "json",
+				Usage:              "output in JSON format",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 0 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			if err := showCacheInfo(ctx, cCtx.Bool("json")); err != nil {
+				return fmt.Errorf("failed to show cache information\n\t%w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// showCacheInfo shows the location, number of entries and total size of the cache.
+func showCacheInfo(ctx *context.Context, jsonFlag bool) error {
+	c, err := cache.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the cache\n\t%w", err)
+	}
+
+	totalSize, err := c.TotalSize()
+	if err != nil {
+		return fmt.Errorf("failed to get the total size of the cache\n\t%w", err)
+	}
+
+	entryCount := len(c.Entries())
+
+	if jsonFlag {
+		jsonBytes, err := json.Marshal(map[string]interface{}{
+			"location":   c.Dir().LocalString(),
+			"entries":    entryCount,
+			"total_size": totalSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+		}
+
+		fmt.Print(string(jsonBytes))
+		return nil
+	}
+
+	fmt.Printf("Location: %v\n", c.Dir().LocalString())
+	fmt.Printf("Entries: %v\n", entryCount)
+	fmt.Printf("Total size: %v\n", cache.FormatSize(totalSize))
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package cmdlipcachelist
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/cache"
+	"github.com/lippkg/lip/internal/context"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "list",
+		Usage:       "list cached files",
+		Description: "List cached files with their URLs, sizes, ages and the teeth they belong to.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "json",
+				Usage:              "output in JSON format",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 0 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			if err := listCache(ctx, cCtx.Bool("json")); err != nil {
+				return fmt.Errorf("failed to list the cache\n\t%w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// listCache lists all entries in the cache.
+func listCache(ctx *context.Context, jsonFlag bool) error {
+	c, err := cache.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the cache\n\t%w", err)
+	}
+
+	entries := c.Entries()
+
+	if jsonFlag {
+		jsonBytes, err := json.Marshal(entries)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+		}
+
+		fmt.Print(string(jsonBytes))
+		return nil
+	}
+
+	tableString := &strings.Builder{}
+	table := tablewriter.NewWriter(tableString)
+	table.SetHeader([]string{
+		"URL", "Size", "Age", "Tooth",
+	})
+
+	for _, entry := range entries {
+		toothStr := entry.Tooth
+		if entry.Version != "" {
+			toothStr = fmt.Sprintf("%v@%v", entry.Tooth, entry.Version)
+		}
+
+		table.Append([]string{
+			entry.URL,
+			cache.FormatSize(entry.Size),
+			formatAge(time.Since(entry.FetchedAt)),
+			toothStr,
+		})
+	}
+
+	table.Render()
+
+	fmt.Print(tableString.String())
+
+	return nil
+}
+
+// formatAge formats a duration in its largest unit, e.g. 3d.
+func formatAge(age time.Duration) string {
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%vd", int(age/(24*time.Hour)))
+	case age >= time.Hour:
+		return fmt.Sprintf("%vh", int(age/time.Hour))
+	case age >= time.Minute:
+		return fmt.Sprintf("%vm", int(age/time.Minute))
+	default:
+		return fmt.Sprintf("%vs", int(age/time.Second))
+	}
+}
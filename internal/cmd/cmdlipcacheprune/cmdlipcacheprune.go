@@ -0,0 +1,224 @@
+package cmdlipcacheprune
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/cache"
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/tooth"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:  "prune",
+		Usage: "remove old, unused or excess cached files",
+		Description: "Remove cached files selected by the given options. At least one option is required. " +
+			"With --max-size, the least recently used files are removed first.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "older-than",
+				Usage: "remove files fetched longer ago than the duration, e.g. 30d or 12h",
+			},
+			&cli.StringFlag{
+				Name:  "max-size",
+				Usage: "remove least recently used files until the cache is not larger than the size, e.g. 1GiB",
+			},
+			&cli.BoolFlag{
+				Name:               "unused",
+				Usage:              "remove files not used by any installed tooth in known workspaces",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 0 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			if !cCtx.IsSet("older-than") && !cCtx.IsSet("max-size") && !cCtx.Bool("unused") {
+				return fmt.Errorf("at least one of --older-than, --max-size and --unused is required")
+			}
+
+			var olderThan time.Duration
+			if cCtx.IsSet("older-than") {
+				var err error
+				olderThan, err = parseAge(cCtx.String("older-than"))
+				if err != nil {
+					return err
+				}
+			}
+
+			maxSize := int64(-1)
+			if cCtx.IsSet("max-size") {
+				var err error
+				maxSize, err = cache.ParseSize(cCtx.String("max-size"))
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := pruneCache(ctx, olderThan, maxSize, cCtx.Bool("unused")); err != nil {
+				return fmt.Errorf("failed to prune the cache\n\t%w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// pruneCache removes entries fetched longer ago than olderThan, entries of teeth not
+// installed in any known workspace if unused is true, and then least recently used entries until
+// the cache is not larger than maxSize. Zero olderThan and negative maxSize are ignored.
+func pruneCache(ctx *context.Context, olderThan time.Duration, maxSize int64, unused bool) error {
+	c, err := cache.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the cache\n\t%w", err)
+	}
+
+	var usedTeeth map[string]bool
+	if unused {
+		usedTeeth, err = getInstalledTeeth(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get installed teeth\n\t%w", err)
+		}
+	}
+
+	toRemove := make(map[string]bool)
+	remaining := make([]cache.Entry, 0)
+
+	for _, entry := range c.Entries() {
+		switch {
+		case olderThan > 0 && time.Since(entry.FetchedAt) > olderThan:
+			toRemove[entry.URL] = true
+
+		// Entries of unknown teeth may be used by anything, so they are kept.
+		case unused && entry.Tooth != "" && entry.Version != "" && !usedTeeth[entry.Tooth+"@"+entry.Version]:
+			toRemove[entry.URL] = true
+
+		default:
+			remaining = append(remaining, entry)
+		}
+	}
+
+	if maxSize >= 0 {
+		// Evict least recently used entries. A blob is freed only when no remaining
+		// entry references it.
+		sort.SliceStable(remaining, func(i, j int) bool {
+			return lastUsed(remaining[i]).Before(lastUsed(remaining[j]))
+		})
+
+		blobRefs := make(map[string]int)
+		blobSizes := make(map[string]int64)
+		for _, entry := range remaining {
+			blobRefs[entry.Blob]++
+			blobSizes[entry.Blob] = entry.Size
+		}
+
+		var totalSize int64
+		for _, size := range blobSizes {
+			totalSize += size
+		}
+
+		for _, entry := range remaining {
+			if totalSize <= maxSize {
+				break
+			}
+
+			toRemove[entry.URL] = true
+
+			blobRefs[entry.Blob]--
+			if blobRefs[entry.Blob] == 0 {
+				totalSize -= blobSizes[entry.Blob]
+			}
+		}
+	}
+
+	if len(toRemove) == 0 {
+		log.Info("Nothing to prune")
+		return nil
+	}
+
+	urlStrs := make([]string, 0, len(toRemove))
+	for urlStr := range toRemove {
+		urlStrs = append(urlStrs, urlStr)
+	}
+	sort.Strings(urlStrs)
+
+	if err := c.Remove(urlStrs...); err != nil {
+		return err
+	}
+
+	for _, urlStr := range urlStrs {
+		log.Infof("Removed %v", urlStr)
+	}
+
+	return nil
+}
+
+// getInstalledTeeth returns the set of teeth installed in the current workspace
+// and in all known workspaces, in the form of <tooth>@<version>.
+func getInstalledTeeth(ctx *context.Context) (map[string]bool, error) {
+	workspaces, err := ctx.KnownWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	workspaces = append(workspaces, workspaceDir)
+
+	installedTeeth := make(map[string]bool)
+	for _, workspace := range workspaces {
+		metadataList, err := tooth.ListInstalledInWorkspace(workspace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list teeth installed in %v\n\t%w", workspace.LocalString(), err)
+		}
+
+		for _, metadata := range metadataList {
+			installedTeeth[metadata.ToothRepoPath()+"@"+metadata.Version().String()] = true
+		}
+	}
+
+	return installedTeeth, nil
+}
+
+// lastUsed returns the time an entry was last used. Entries never used since
+// being fetched are considered used at the time of fetching.
+func lastUsed(entry cache.Entry) time.Time {
+	if entry.LastUsed.IsZero() {
+		return entry.FetchedAt
+	}
+
+	return entry.LastUsed
+}
+
+// parseAge parses a duration. In addition to the units of time.ParseDuration,
+// a plain number of days like 30d is accepted.
+func parseAge(ageStr string) (time.Duration, error) {
+	if strings.HasSuffix(ageStr, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(ageStr, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %v", ageStr)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(ageStr)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid duration %v", ageStr)
+	}
+
+	return age, nil
+}
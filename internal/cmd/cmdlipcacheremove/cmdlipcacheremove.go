@@ -0,0 +1,69 @@
+package cmdlipcacheremove
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lippkg/lip/internal/cache"
+	"github.com/lippkg/lip/internal/context"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:      "remove",
+		Usage:     "remove cached files matching a pattern",
+		ArgsUsage: "<pattern>",
+		Description: "Remove cached files whose URL or tooth repository path matches the pattern. " +
+			"'*' in the pattern matches any sequence of characters.",
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 1 {
+				return fmt.Errorf("expected exactly one pattern")
+			}
+
+			if err := removeFromCache(ctx, cCtx.Args().First()); err != nil {
+				return fmt.Errorf("failed to remove from the cache\n\t%w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// removeFromCache removes entries whose URL or tooth repository path matches the pattern.
+func removeFromCache(ctx *context.Context, pattern string) error {
+	c, err := cache.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open the cache\n\t%w", err)
+	}
+
+	patternRegexp := regexp.MustCompile(
+		"^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+
+	urlStrs := make([]string, 0)
+	for _, entry := range c.Entries() {
+		if patternRegexp.MatchString(entry.URL) || (entry.Tooth != "" && patternRegexp.MatchString(entry.Tooth)) {
+			urlStrs = append(urlStrs, entry.URL)
+		}
+	}
+
+	if len(urlStrs) == 0 {
+		log.Infof("No cached files match %v", pattern)
+		return nil
+	}
+
+	if err := c.Remove(urlStrs...); err != nil {
+		return err
+	}
+
+	for _, urlStr := range urlStrs {
+		log.Infof("Removed %v", urlStr)
+	}
+
+	return nil
+}
@@ -1,35 +1,99 @@
 package cmdlipconfig
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"reflect"
-	"strconv"
 	"strings"
 
 	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/network"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli/v2"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const descriptionText = `Manage configuration.
-  
+
   - If no arguments are specified, list all configuration.
   - If a key is specified, print the value of the key.
-  - If a key and a value are specified, set the value of the key.`
+  - If a key and a value are specified, set the value of the key in the user config file.
+
+Lists are given as comma-separated values or JSON arrays, and maps as JSON objects.
+A single entry of a map is addressed as <key>.<entry>, e.g. auth.example.com.
+
+Configuration is resolved from the system config file, the user config file
+(~/.lip/config.json), the workspace config file (.lip/config.json), LIP_*
+environment variables and --config flags, in order of increasing precedence.
+
+Keys:
+`
+
+// redactedSecret is shown in place of secrets, such as tokens and passwords in auth.
+const redactedSecret = "***"
 
 func Command(ctx *context.Context) *cli.Command {
 	return &cli.Command{
 		Name:        "config",
 		Usage:       "manage configuration",
-		Description: descriptionText,
+		Description: descriptionText + describeKeys(),
 		ArgsUsage:   "[<key> [<value>]]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "show-origin",
+				Usage:              "show where each value came from",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "json",
+				Usage:              "output in JSON format",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "unset",
+				Usage:              "remove the key from the user config file",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "reset",
+				Usage:              "remove all keys from the user config file",
+				DisableDefaultText: true,
+			},
+		},
 		Action: func(cCtx *cli.Context) error {
+			if cCtx.Bool("unset") {
+				if cCtx.NArg() != 1 {
+					return fmt.Errorf("expected exactly one key to unset")
+				}
+
+				if err := unsetConfig(ctx, cCtx.Args().Get(0)); err != nil {
+					return fmt.Errorf("failed to unset config\n\t%w", err)
+				}
+
+				return nil
+			}
+
+			if cCtx.Bool("reset") {
+				if cCtx.NArg() != 0 {
+					return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+				}
+
+				if err := ctx.ResetUserConfig(); err != nil {
+					return fmt.Errorf("failed to reset config\n\t%w", err)
+				}
+
+				return nil
+			}
+
 			switch cCtx.NArg() {
 			case 0:
-				showAllConfig(ctx)
+				if err := showAllConfig(ctx, cCtx.Bool("show-origin"), cCtx.Bool("json")); err != nil {
+					return fmt.Errorf("failed to show config\n\t%w", err)
+				}
 
 			case 1:
-				if err := showConfig(ctx, cCtx.Args().Get(0)); err != nil {
+				if err := showConfig(ctx, cCtx.Args().Get(0), cCtx.Bool("show-origin"), cCtx.Bool("json")); err != nil {
 					return fmt.Errorf("failed to show config\n\t%w", err)
 				}
 
@@ -47,125 +111,314 @@ func Command(ctx *context.Context) *cli.Command {
 	}
 }
 
-func convertConfigToMap(config context.Config) map[string]interface{} {
-	value := reflect.ValueOf(config)
-	typeOfValue := value.Type()
+// ---------------------------------------------------------------------
+
+// describeKeys lists all config keys with their kinds and descriptions for help.
+func describeKeys() string {
+	builder := &strings.Builder{}
+	for _, key := range context.ConfigKeys() {
+		kind := string(key.Kind)
+		if key.Kind == context.ConfigKindEnum {
+			kind = strings.Join(key.Values, "|")
+		}
 
-	configMap := make(map[string]interface{})
-	for i := 0; i < value.NumField(); i++ {
-		configMap[typeOfValue.Field(i).Name] = value.Field(i).Interface()
+		fmt.Fprintf(builder, "  - %v (%v): %v\n", key.Name, kind, key.Description)
 	}
 
-	return configMap
+	return builder.String()
 }
 
-func convertStringToType(str string, targetType reflect.Type) (interface{}, error) {
-	switch targetType.Kind() {
-	case reflect.String:
-		return str, nil
+// formatValue formats a JSON value for display. Strings are shown without quotes.
+func formatValue(value json.RawMessage) string {
+	var valueStr string
+	if err := json.Unmarshal(value, &valueStr); err == nil {
+		return valueStr
+	}
+
+	return formatJSON(value)
+}
 
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		intValue, err := strconv.ParseInt(str, 10, targetType.Bits())
-		if err != nil {
-			return nil, err
-		}
-		value := reflect.New(targetType).Elem()
-		value.SetInt(intValue)
-		return value.Interface(), nil
+// getRedactedValue returns the effective value of the key, with the secrets of auth
+// replaced by redactedSecret.
+func getRedactedValue(ctx *context.Context, name string) (json.RawMessage, error) {
+	value, err := ctx.Config().Get(name)
+	if err != nil {
+		return nil, err
+	}
 
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		uintValue, err := strconv.ParseUint(str, 10, targetType.Bits())
-		if err != nil {
-			return nil, err
+	if name != "auth" {
+		return value, nil
+	}
+
+	var auth map[string]network.HostAuth
+	if err := json.Unmarshal(value, &auth); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal value of %v\n\t%w", name, err)
+	}
+
+	for host, hostAuth := range auth {
+		if hostAuth.Token != "" {
+			hostAuth.Token = redactedSecret
 		}
-		value := reflect.New(targetType).Elem()
-		value.SetUint(uintValue)
-		return value.Interface(), nil
 
-	case reflect.Float32, reflect.Float64:
-		floatValue, err := strconv.ParseFloat(str, targetType.Bits())
-		if err != nil {
-			return nil, err
+		if hostAuth.Password != "" {
+			hostAuth.Password = redactedSecret
 		}
-		value := reflect.New(targetType).Elem()
-		value.SetFloat(floatValue)
-		return value.Interface(), nil
 
-	case reflect.Bool:
-		boolValue, err := strconv.ParseBool(str)
-		if err != nil {
-			return nil, err
+		for header := range hostAuth.Headers {
+			hostAuth.Headers[header] = redactedSecret
 		}
-		value := reflect.New(targetType).Elem()
-		value.SetBool(boolValue)
-		return value.Interface(), nil
 
-	default:
-		return nil, fmt.Errorf("unsupported type: %v", targetType)
+		auth[host] = hostAuth
+	}
+
+	value, err = json.Marshal(auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal value of %v\n\t%w", name, err)
 	}
+
+	return value, nil
 }
 
-func setConfig(ctx *context.Context, key string, value string) error {
-	field := reflect.ValueOf(ctx.Config()).Elem().FieldByName(key)
-	if !field.IsValid() {
-		return fmt.Errorf("no such key: %v", key)
+// lookupKey splits a key in the form of <key> or <key>.<entry> and looks it up.
+// Entries are only allowed for maps.
+func lookupKey(keyStr string) (context.ConfigKey, string, error) {
+	name, entry, hasEntry := strings.Cut(keyStr, ".")
+
+	key, ok := context.LookupConfigKey(name)
+	if !ok {
+		return context.ConfigKey{}, "", fmt.Errorf("no such key: %v", name)
+	}
+
+	if hasEntry && key.Kind != context.ConfigKindMap {
+		return context.ConfigKey{}, "", fmt.Errorf("%v is not a map", name)
 	}
 
-	if !field.CanSet() {
-		return fmt.Errorf("cannot set key: %v", key)
+	if hasEntry && entry == "" {
+		return context.ConfigKey{}, "", fmt.Errorf("empty entry of %v", name)
 	}
 
-	fieldType := field.Type()
-	structValueInterface, err := convertStringToType(value, fieldType)
+	return key, entry, nil
+}
+
+func setConfig(ctx *context.Context, keyStr string, valueStr string) error {
+	key, entry, err := lookupKey(keyStr)
 	if err != nil {
-		return fmt.Errorf("failed to convert value to type\n\t%w", err)
+		return err
 	}
-	structValue := reflect.ValueOf(structValueInterface)
 
-	if structValue.Type().ConvertibleTo(fieldType) {
-		field.Set(structValue.Convert(fieldType))
+	var value json.RawMessage
+	if entry == "" {
+		value, err = key.ParseValue(valueStr)
+		if err != nil {
+			return err
+		}
 
 	} else {
-		return fmt.Errorf("cannot convert value to type: %v", fieldType)
+		// An entry is a JSON value, or a string if it is not valid JSON.
+		entryValue := json.RawMessage(valueStr)
+		if !json.Valid(entryValue) {
+			entryValue, err = json.Marshal(valueStr)
+			if err != nil {
+				return fmt.Errorf("failed to marshal value\n\t%w", err)
+			}
+		}
+
+		entries, err := getUserMapEntries(ctx, key.Name)
+		if err != nil {
+			return err
+		}
+
+		entries[entry] = entryValue
+
+		value, err = json.Marshal(entries)
+		if err != nil {
+			return fmt.Errorf("failed to marshal value\n\t%w", err)
+		}
+	}
+
+	if err := ctx.SetUserConfigValue(key.Name, value); err != nil {
+		return err
 	}
 
-	if err := ctx.SaveConfigFile(); err != nil {
-		return fmt.Errorf("failed to save config file\n\t%w", err)
+	if ctx.IsConfigOverridden(key.Name) {
+		log.Warnf("%v is overridden by %v", key.Name, ctx.ConfigOrigin(key.Name))
 	}
 
 	return nil
 }
 
-func showAllConfig(ctx *context.Context) {
-	tableData := make([][]string, 0)
-	for key, value := range convertConfigToMap(*ctx.Config()) {
-		tableData = append(tableData, []string{key, fmt.Sprintf("%v", value)})
+func showAllConfig(ctx *context.Context, showOrigin bool, jsonFlag bool) error {
+	if jsonFlag {
+		output := make(map[string]interface{})
+		for _, key := range context.ConfigKeys() {
+			value, err := getRedactedValue(ctx, key.Name)
+			if err != nil {
+				return err
+			}
+
+			if showOrigin {
+				output[key.Name] = map[string]interface{}{
+					"value":  value,
+					"origin": ctx.ConfigOrigin(key.Name),
+				}
+			} else {
+				output[key.Name] = value
+			}
+		}
+
+		jsonBytes, err := json.Marshal(output)
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+		}
+
+		fmt.Print(string(jsonBytes))
+		return nil
 	}
 
 	tableString := &strings.Builder{}
 	table := tablewriter.NewWriter(tableString)
-	table.SetHeader([]string{
-		"Key", "Value",
-	})
+	if showOrigin {
+		table.SetHeader([]string{
+			"Key", "Value", "Origin",
+		})
+	} else {
+		table.SetHeader([]string{
+			"Key", "Value",
+		})
+	}
 
-	for _, row := range tableData {
+	for _, key := range context.ConfigKeys() {
+		value, err := getRedactedValue(ctx, key.Name)
+		if err != nil {
+			return err
+		}
+
+		row := []string{key.Name, formatValue(value)}
+		if showOrigin {
+			row = append(row, ctx.ConfigOrigin(key.Name))
+		}
 		table.Append(row)
 	}
 
 	table.Render()
 
 	fmt.Print(tableString.String())
-}
 
-func showConfig(ctx *context.Context, key string) error {
-	configMap := convertConfigToMap(*ctx.Config())
+	return nil
+}
 
-	if value, ok := configMap[key]; ok {
-		fmt.Printf("%v\n", value)
+func showConfig(ctx *context.Context, keyStr string, showOrigin bool, jsonFlag bool) error {
+	key, entry, err := lookupKey(keyStr)
+	if err != nil {
+		return err
+	}
 
+	// Secrets are only shown when their entry is asked for.
+	var value json.RawMessage
+	if entry == "" {
+		value, err = getRedactedValue(ctx, key.Name)
 	} else {
-		return fmt.Errorf("no such key: %v", key)
+		value, err = ctx.Config().Get(key.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry != "" {
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(value, &entries); err != nil {
+			return fmt.Errorf("failed to unmarshal value of %v\n\t%w", key.Name, err)
+		}
+
+		entryValue, ok := entries[entry]
+		if !ok {
+			return fmt.Errorf("no such entry: %v", keyStr)
+		}
+
+		value = entryValue
+	}
+
+	switch {
+	case jsonFlag && showOrigin:
+		jsonBytes, err := json.Marshal(map[string]interface{}{
+			"value":  value,
+			"origin": ctx.ConfigOrigin(key.Name),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+		}
+
+		fmt.Print(string(jsonBytes))
+
+	case jsonFlag:
+		fmt.Print(formatJSON(value))
+
+	case showOrigin:
+		fmt.Printf("%v\t%v\n", ctx.ConfigOrigin(key.Name), formatValue(value))
+
+	default:
+		fmt.Printf("%v\n", formatValue(value))
 	}
 
 	return nil
 }
+
+func unsetConfig(ctx *context.Context, keyStr string) error {
+	key, entry, err := lookupKey(keyStr)
+	if err != nil {
+		return err
+	}
+
+	if entry == "" {
+		return ctx.UnsetUserConfigValue(key.Name)
+	}
+
+	entries, err := getUserMapEntries(ctx, key.Name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := entries[entry]; !ok {
+		return fmt.Errorf("no such entry in the user config file: %v", keyStr)
+	}
+
+	delete(entries, entry)
+
+	value, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value\n\t%w", err)
+	}
+
+	return ctx.SetUserConfigValue(key.Name, value)
+}
+
+// formatJSON compacts a JSON value for output.
+func formatJSON(value json.RawMessage) string {
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, value); err != nil {
+		return string(value)
+	}
+
+	return compacted.String()
+}
+
+// getUserMapEntries returns the entries of a map in the user config file. It is
+// empty if the key is not set in the user config file.
+func getUserMapEntries(ctx *context.Context, name string) (map[string]json.RawMessage, error) {
+	entries := make(map[string]json.RawMessage)
+
+	value, ok := ctx.UserConfigValue(name)
+	if !ok {
+		return entries, nil
+	}
+
+	if err := json.Unmarshal(value, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal value of %v in the user config file\n\t%w", name, err)
+	}
+
+	if entries == nil {
+		entries = make(map[string]json.RawMessage)
+	}
+
+	return entries, nil
+}
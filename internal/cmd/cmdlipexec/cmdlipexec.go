@@ -0,0 +1,102 @@
+package cmdlipexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/install"
+	"github.com/lippkg/lip/internal/tooth"
+	"github.com/urfave/cli/v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const descriptionText = `
+Run an executable declared in the bin field of an installed tooth, with the
+environment of the workspace. The shims of all executables are found in PATH.
+Arguments after the name, optionally separated by "--", are passed to the
+executable.
+`
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "exec",
+		Usage:       "run an executable of an installed tooth",
+		Description: descriptionText,
+		ArgsUsage:   "<name> [--] [args...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "global",
+				Aliases:            []string{"g"},
+				Usage:              "run an executable of the global workspace",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			debugLogger := log.WithFields(log.Fields{
+				"package": "cmdlipexec",
+				"method":  "Action",
+			})
+
+			if cCtx.NArg() == 0 {
+				return fmt.Errorf("the name of an executable is required")
+			}
+
+			if cCtx.Bool("global") {
+				if err := ctx.UseGlobalWorkspace(); err != nil {
+					return fmt.Errorf("failed to use global workspace\n\t%w", err)
+				}
+			}
+
+			name := cCtx.Args().First()
+			args := cCtx.Args().Tail()
+			if len(args) != 0 && args[0] == "--" {
+				args = args[1:]
+			}
+
+			installedStore, err := tooth.LoadInstalledStore(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load installed teeth\n\t%w", err)
+			}
+
+			target, err := install.LookupExecutable(ctx, installedStore, name)
+			if err != nil {
+				return err
+			}
+
+			environs, err := install.WorkspaceEnvirons(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to make environment variables\n\t%w", err)
+			}
+
+			cmd := exec.Command(target.LocalString(), args...)
+			cmd.Env = environs
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+
+			// Interrupts are left to the executable, which gets them as well.
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt)
+			defer signal.Stop(signals)
+
+			debugLogger.Debugf("Running %v %v", target.LocalString(), args)
+
+			err = cmd.Run()
+
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				// Exit with the exit code of the executable.
+				return cli.Exit("", exitErr.ExitCode())
+			} else if err != nil {
+				return fmt.Errorf("failed to run executable %v\n\t%w", name, err)
+			}
+
+			return nil
+		},
+	}
+}
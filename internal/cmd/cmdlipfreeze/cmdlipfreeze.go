@@ -36,11 +36,11 @@ func Command(ctx *context.Context) *cli.Command {
 
 			var specifiers string
 
-			metadataList, err := tooth.GetAllMetadata(ctx)
+			installedStore, err := tooth.LoadInstalledStore(ctx)
 			if err != nil {
 				return fmt.Errorf("failed to get all installed teeth\n\t%w", err)
 			}
-			for _, i := range metadataList {
+			for _, i := range installedStore.All() {
 				specifiers += fmt.Sprintf("%v@%v\n", i.ToothRepoPath(), i.Version().String())
 			}
 
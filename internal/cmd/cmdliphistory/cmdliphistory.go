@@ -0,0 +1,96 @@
+package cmdliphistory
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/tooth"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli/v2"
+)
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "history",
+		Usage:       "list transactions of installed teeth",
+		Description: "List the recorded install, upgrade, uninstall and rollback transactions of the workspace.",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "json",
+				Usage:              "output in JSON format",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			// Check if there are unexpected arguments.
+			if cCtx.NArg() != 0 {
+				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
+			}
+
+			transactions, err := tooth.ListTransactions(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list transactions\n\t%w", err)
+			}
+
+			if cCtx.Bool("json") {
+				jsonBytes, err := json.Marshal(transactions)
+				if err != nil {
+					return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+				}
+
+				fmt.Print(string(jsonBytes))
+
+				return nil
+			}
+
+			tableString := &strings.Builder{}
+			table := tablewriter.NewWriter(tableString)
+			table.SetHeader([]string{
+				"ID", "Time", "Command", "Status", "Changes",
+			})
+			table.SetAutoWrapText(false)
+
+			for _, transaction := range transactions {
+				table.Append([]string{
+					strconv.Itoa(transaction.ID),
+					transaction.StartedAt.Local().Format(time.RFC3339),
+					transaction.Command,
+					transaction.Status,
+					formatChanges(transaction.Changes),
+				})
+			}
+
+			table.Render()
+
+			fmt.Print(tableString.String())
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// formatChanges formats changes one per line, e.g. "example.com/a/b 1.0.0 -> 1.1.0".
+func formatChanges(changes []tooth.TransactionChange) string {
+	lines := make([]string, 0, len(changes))
+	for _, change := range changes {
+		beforeVersion := change.BeforeVersion
+		if beforeVersion == "" {
+			beforeVersion = "(none)"
+		}
+
+		afterVersion := change.AfterVersion
+		if afterVersion == "" {
+			afterVersion = "(none)"
+		}
+
+		lines = append(lines, fmt.Sprintf("%v %v -> %v", change.Tooth, beforeVersion, afterVersion))
+	}
+
+	return strings.Join(lines, "\n")
+}
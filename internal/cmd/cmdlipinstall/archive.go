@@ -6,13 +6,12 @@ import (
 	"github.com/blang/semver/v4"
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/install"
-	"github.com/lippkg/lip/internal/network"
 	"github.com/lippkg/lip/internal/path"
 	"github.com/lippkg/lip/internal/tooth"
 	log "github.com/sirupsen/logrus"
 )
 
-func filterInstalledToothArchives(ctx *context.Context, archives []tooth.Archive, upgradeFlag bool,
+func filterInstalledToothArchives(installedStore *tooth.InstalledStore, archives []tooth.Archive, upgradeFlag bool,
 	forceReinstallFlag bool) ([]tooth.Archive, error) {
 
 	if forceReinstallFlag {
@@ -21,15 +20,10 @@ func filterInstalledToothArchives(ctx *context.Context, archives []tooth.Archive
 
 	filteredArchives := make([]tooth.Archive, 0)
 	for _, archive := range archives {
-		isInstalled, err := tooth.IsInstalled(ctx, archive.Metadata().ToothRepoPath())
-		if err != nil {
-			return nil, fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
-		}
-
-		if !isInstalled {
+		if !installedStore.IsInstalled(archive.Metadata().ToothRepoPath()) {
 			filteredArchives = append(filteredArchives, archive)
 		} else if upgradeFlag {
-			currentMetadata, err := tooth.GetMetadata(ctx, archive.Metadata().ToothRepoPath())
+			currentMetadata, err := installedStore.Get(archive.Metadata().ToothRepoPath())
 			if err != nil {
 				return nil, fmt.Errorf("failed to find installed tooth metadata\n\t%w", err)
 			}
@@ -47,16 +41,21 @@ func filterInstalledToothArchives(ctx *context.Context, archives []tooth.Archive
 	return filteredArchives, nil
 }
 
-// installToothArchive installs the tooth archive.
-func installToothArchive(ctx *context.Context, archive tooth.Archive, forceReinstall bool, upgrade bool, yes bool) error {
+// installToothArchive installs the tooth archive. specifier is the specifier typed
+// by the user, or empty for teeth installed as dependencies.
+func installToothArchive(ctx *context.Context, installedStore *tooth.InstalledStore, archive tooth.Archive,
+	specifier string, conflictResolver *install.ConflictResolver, forceReinstall bool, upgrade bool, allowScripts bool,
+	ignoreScripts bool) error {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "cmdlipinstall",
 		"method":  "installToothArchive",
 	})
 
-	isInstalled, err := tooth.IsInstalled(ctx, archive.Metadata().ToothRepoPath())
-	if err != nil {
-		return fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
+	isInstalled := installedStore.IsInstalled(archive.Metadata().ToothRepoPath())
+
+	reason := tooth.InstallReasonExplicit
+	if specifier == "" {
+		reason = tooth.InstallReasonDependency
 	}
 
 	shouldInstall := false
@@ -69,8 +68,7 @@ func installToothArchive(ctx *context.Context, archive tooth.Archive, forceReins
 		shouldUninstall = true
 
 	} else if isInstalled && upgrade {
-		currentMetadata, err := tooth.GetMetadata(ctx,
-			archive.Metadata().ToothRepoPath())
+		currentMetadata, err := installedStore.Get(archive.Metadata().ToothRepoPath())
 		if err != nil {
 			return fmt.Errorf("failed to find installed tooth metadata\n\t%w", err)
 		}
@@ -100,8 +98,16 @@ func installToothArchive(ctx *context.Context, archive tooth.Archive, forceReins
 		shouldUninstall = false
 	}
 
+	var previous *tooth.InstalledTooth
 	if shouldUninstall {
-		err := install.Uninstall(ctx, archive.Metadata().ToothRepoPath())
+		installedTooth, ok := installedStore.Lookup(archive.Metadata().ToothRepoPath())
+		if !ok {
+			return fmt.Errorf("failed to find installed tooth record: %v", archive.Metadata().ToothRepoPath())
+		}
+
+		previous = &installedTooth
+
+		err := install.Uninstall(ctx, installedStore, archive.Metadata().ToothRepoPath(), allowScripts, ignoreScripts)
 		if err != nil {
 			return fmt.Errorf("failed to uninstall tooth\n\t%w", err)
 		}
@@ -109,30 +115,16 @@ func installToothArchive(ctx *context.Context, archive tooth.Archive, forceReins
 	}
 
 	if shouldInstall {
-		assetURL, err := archive.Metadata().AssetURL()
+		assetDownloadURL, err := getAssetDownloadURL(ctx, archive.Metadata())
 		if err != nil {
-			return fmt.Errorf("failed to get asset URL\n\t%w", err)
+			return err
 		}
 
 		assetArchiveFilePath := path.MakeEmpty()
-		if assetURL.String() != "" {
-			gitHubMirrorURL, err := ctx.GitHubMirrorURL()
+		if assetDownloadURL != nil {
+			cachePath, err := getCachePath(ctx, assetDownloadURL)
 			if err != nil {
-				return fmt.Errorf("failed to get GitHub mirror URL\n\t%w", err)
-			}
-
-			mirroredURL := assetURL
-			if network.IsGitHubDirectDownloadURL(assetURL) {
-				// Rewrite GitHub URL to GitHub mirror URL if it is set.
-				mirroredURL, err = network.GenerateGitHubMirrorURL(assetURL, gitHubMirrorURL)
-				if err != nil {
-					return fmt.Errorf("failed to generate GitHub mirror URL\n\t%w", err)
-				}
-			}
-
-			cachePath, err := getCachePath(ctx, mirroredURL)
-			if err != nil {
-				return fmt.Errorf("failed to get cache path of asset URL %v\n\t%w", assetURL, err)
+				return fmt.Errorf("failed to get cache path of asset URL %v\n\t%w", assetDownloadURL, err)
 			}
 
 			assetArchiveFilePath = cachePath
@@ -143,10 +135,21 @@ func installToothArchive(ctx *context.Context, archive tooth.Archive, forceReins
 			return fmt.Errorf("failed to attach asset archive %v\n\t%w", assetArchiveFilePath.LocalString(), err)
 		}
 
-		if err := install.Install(ctx, archiveWithAssets, yes); err != nil {
+		provenance, err := makeProvenance(ctx, archiveWithAssets, assetDownloadURL, specifier)
+		if err != nil {
+			return fmt.Errorf("failed to record provenance\n\t%w", err)
+		}
+
+		if err := install.Install(ctx, installedStore, archiveWithAssets, previous, reason, provenance,
+			conflictResolver, allowScripts, ignoreScripts); err != nil {
 			return fmt.Errorf("failed to install tooth archive %v\n\t%w", archiveWithAssets.FilePath().LocalString(), err)
 		}
 		debugLogger.Debugf("Installed tooth archive %v", archiveWithAssets.FilePath().LocalString())
+
+		// Record the workspace so that cache pruning knows which files are still in use.
+		if err := ctx.RegisterWorkspace(); err != nil {
+			return fmt.Errorf("failed to register workspace\n\t%w", err)
+		}
 	}
 
 	return nil
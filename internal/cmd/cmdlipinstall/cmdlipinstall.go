@@ -6,7 +6,9 @@ import (
 	"strings"
 
 	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/install"
 	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/prompt"
 	"github.com/lippkg/lip/internal/specifier"
 	"github.com/urfave/cli/v2"
 
@@ -21,6 +23,22 @@ Install teeth from:
 - local tooth archives. (e.g. "./foo.tth")
 `
 
+// Options configures InstallSpecifiers. The fields are the flags of lip install.
+type Options struct {
+	Upgrade        bool
+	ForceReinstall bool
+	NoDependencies bool
+	Yes            bool
+	AllowScripts   bool
+	IgnoreScripts  bool
+	// OnConflict is one of the install.OnConflict* policies.
+	OnConflict string
+	// ToothArchiveURLs maps tooth repo paths to recorded URLs of their tooth
+	// archives, which are used instead of the Go module proxy for specifiers with
+	// versions. It is not a flag of lip install.
+	ToothArchiveURLs map[string]string
+}
+
 func Command(ctx *context.Context) *cli.Command {
 	return &cli.Command{
 		Name:        "install",
@@ -49,6 +67,27 @@ func Command(ctx *context.Context) *cli.Command {
 				Usage:              "do not install dependencies. Also bypass prerequisite checks",
 				DisableDefaultText: true,
 			},
+			&cli.BoolFlag{
+				Name:               "allow-scripts",
+				Usage:              "run commands declared by teeth without asking under the prompt script policy",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "ignore-scripts",
+				Usage:              "do not run commands declared by teeth",
+				DisableDefaultText: true,
+			},
+			&cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "what to do with existing destinations: backup, overwrite, skip or abort",
+				Value: install.OnConflictBackup,
+			},
+			&cli.BoolFlag{
+				Name:               "global",
+				Aliases:            []string{"g"},
+				Usage:              "install into the global workspace",
+				DisableDefaultText: true,
+			},
 			&cli.BoolFlag{
 				Name:               "specifiers",
 				Aliases:            []string{"s"},
@@ -67,7 +106,22 @@ func Command(ctx *context.Context) *cli.Command {
 				return fmt.Errorf("at least one specifier is required")
 			}
 
-			log.Info("Downloading teeth and resolving dependencies...")
+			if cCtx.Bool("global") {
+				if err := ctx.UseGlobalWorkspace(); err != nil {
+					return fmt.Errorf("failed to use global workspace\n\t%w", err)
+				}
+			}
+
+			workspaceLock, err := ctx.LockWorkspace()
+			if err != nil {
+				return err
+			}
+			defer workspaceLock.Release()
+
+			installedStore, err := tooth.LoadInstalledStore(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load installed teeth\n\t%w", err)
+			}
 
 			// Parse specifiers.
 			specifiers := make([]specifier.Specifier, 0)
@@ -117,99 +171,194 @@ func Command(ctx *context.Context) *cli.Command {
 				}
 			}
 
-			debugLogger.Debug("Got specifiers from arguments:")
-			for _, specifier := range specifiers {
-				debugLogger.Debugf("  %v", specifier)
-			}
+			installedStore.BeginTransaction(getTransactionCommand(specifiers))
 
-			// Download remote tooth archives. Then open all specified tooth archives.
-
-			specifiedArchives, err := resolveSpecifiers(ctx, specifiers)
+			err = InstallSpecifiers(ctx, installedStore, specifiers, Options{
+				Upgrade:        cCtx.Bool("upgrade"),
+				ForceReinstall: cCtx.Bool("force-reinstall"),
+				NoDependencies: cCtx.Bool("no-dependencies"),
+				Yes:            cCtx.Bool("yes"),
+				AllowScripts:   cCtx.Bool("allow-scripts"),
+				IgnoreScripts:  cCtx.Bool("ignore-scripts"),
+				OnConflict:     cCtx.String("on-conflict"),
+			})
+			installedStore.EndTransaction(err)
 			if err != nil {
-				return fmt.Errorf("failed to parse and download specifier string list\n\t%w", err)
+				return err
 			}
 
-			debugLogger.Debug("Got tooth archives from specifiers:")
-			for _, archive := range specifiedArchives {
-				debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
-			}
+			log.Info("Done.")
 
-			// Resolve dependencies and check prerequisites.
+			return nil
+		},
+	}
+}
 
-			archivesToInstall := specifiedArchives
-			if !cCtx.Bool("no-dependencies") {
-				archives, err := resolveDependencies(ctx, specifiedArchives, cCtx.Bool("upgrade"),
-					cCtx.Bool("force-reinstall"))
-				if err != nil {
-					return fmt.Errorf("failed to resolve dependencies\n\t%w", err)
-				}
+// InstallSpecifiers installs the teeth of specifiers as lip install does. Changes
+// are recorded in the current transaction of installedStore, if any.
+func InstallSpecifiers(ctx *context.Context, installedStore *tooth.InstalledStore,
+	specifiers []specifier.Specifier, options Options) error {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "cmdlipinstall",
+		"method":  "InstallSpecifiers",
+	})
+
+	// Decide what to do with existing destinations once for the run.
+	conflictResolver, err := install.NewConflictResolver(ctx, options.OnConflict)
+	if err != nil {
+		return err
+	}
 
-				archivesToInstall = archives
+	log.Info("Downloading teeth and resolving dependencies...")
 
-				debugLogger.Debug("After resolving dependencies, got tooth archives to install:")
-				for _, archive := range archivesToInstall {
-					debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
-				}
+	debugLogger.Debug("Got specifiers from arguments:")
+	for _, specifier := range specifiers {
+		debugLogger.Debugf("  %v", specifier)
+	}
 
-				_, missingPrerequisites, err := getMissingPrerequisites(ctx, archivesToInstall)
-				if err != nil {
-					return fmt.Errorf("failed to find missing prerequisites\n\t%w", err)
-				}
+	// Download remote tooth archives. Then open all specified tooth archives.
 
-				if len(missingPrerequisites) != 0 {
-					message := "Missing prerequisites:\n"
-					for prerequisite, versionRangeString := range missingPrerequisites {
-						message += fmt.Sprintf("  %v: %v\n", prerequisite, versionRangeString)
-					}
-					return fmt.Errorf(message)
-				}
-			}
+	specifiedArchives, err := resolveSpecifiers(ctx, specifiers, options.ToothArchiveURLs)
+	if err != nil {
+		return fmt.Errorf("failed to parse and download specifier string list\n\t%w", err)
+	}
 
-			// Filter installed teeth.
+	debugLogger.Debug("Got tooth archives from specifiers:")
+	for _, archive := range specifiedArchives {
+		debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
+	}
 
-			filteredArchives, err := filterInstalledToothArchives(ctx, archivesToInstall, cCtx.Bool("upgrade"),
-				cCtx.Bool("force-reinstall"))
-			if err != nil {
-				return fmt.Errorf("failed to filter installed teeth\n\t%w", err)
-			}
+	// Resolve dependencies and check prerequisites.
 
-			debugLogger.Debug("After filtering installed teeth, got archives to install:")
-			for _, archive := range filteredArchives {
-				debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
-			}
+	archivesToInstall := specifiedArchives
+	if !options.NoDependencies {
+		archives, err := resolveDependencies(ctx, installedStore, specifiedArchives, options.Upgrade,
+			options.ForceReinstall)
+		if err != nil {
+			return fmt.Errorf("failed to resolve dependencies\n\t%w", err)
+		}
 
-			// Download tooth assets if necessary.
+		archivesToInstall = archives
 
-			for _, archive := range filteredArchives {
-				if err := downloadToothAssetArchiveIfNotCached(ctx, archive); err != nil {
-					return fmt.Errorf("failed to download tooth assets\n\t%w", err)
-				}
-			}
+		debugLogger.Debug("After resolving dependencies, got tooth archives to install:")
+		for _, archive := range archivesToInstall {
+			debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
+		}
 
-			// Ask for confirmation.
+		_, missingPrerequisites, err := getMissingPrerequisites(installedStore, archivesToInstall)
+		if err != nil {
+			return fmt.Errorf("failed to find missing prerequisites\n\t%w", err)
+		}
 
-			if !cCtx.Bool("yes") {
-				err := askForConfirmation(ctx, filteredArchives)
-				if err != nil {
-					return err
-				}
+		if len(missingPrerequisites) != 0 {
+			message := "Missing prerequisites:\n"
+			for prerequisite, versionRangeString := range missingPrerequisites {
+				message += fmt.Sprintf("  %v: %v\n", prerequisite, versionRangeString)
 			}
+			return fmt.Errorf(message)
+		}
+	}
 
-			// Install teeth.
+	// Filter installed teeth.
 
-			log.Info("Installing teeth...")
+	filteredArchives, err := filterInstalledToothArchives(installedStore, archivesToInstall, options.Upgrade,
+		options.ForceReinstall)
+	if err != nil {
+		return fmt.Errorf("failed to filter installed teeth\n\t%w", err)
+	}
 
-			for _, archive := range filteredArchives {
-				if err := installToothArchive(ctx, archive, cCtx.Bool("force-reinstall"), cCtx.Bool("upgrade"), cCtx.Bool("yes")); err != nil {
-					return fmt.Errorf("failed to install tooth archive %v\n\t%w", archive.FilePath().LocalString(), err)
-				}
-			}
+	debugLogger.Debug("After filtering installed teeth, got archives to install:")
+	for _, archive := range filteredArchives {
+		debugLogger.Debugf("  %v@%v: %v", archive.Metadata().ToothRepoPath(), archive.Metadata().Version(), archive.FilePath().LocalString())
+	}
 
-			log.Info("Done.")
+	// Check that the workspace defines the roots the teeth reference, before
+	// anything is uninstalled for upgrading.
 
-			return nil
-		},
+	roots, err := ctx.Roots()
+	if err != nil {
+		return fmt.Errorf("failed to get roots\n\t%w", err)
+	}
+
+	metadataList := make([]tooth.Metadata, 0, len(filteredArchives))
+	for _, archive := range filteredArchives {
+		metadata, err := archive.Metadata().ToRootsExpanded(roots)
+		if err != nil {
+			return err
+		}
+
+		metadataList = append(metadataList, metadata)
+	}
+
+	if err := checkExecutableNames(installedStore, metadataList); err != nil {
+		return err
+	}
+
+	// Download tooth assets if necessary.
+
+	for _, archive := range filteredArchives {
+		if err := downloadToothAssetArchiveIfNotCached(ctx, archive); err != nil {
+			return fmt.Errorf("failed to download tooth assets\n\t%w", err)
+		}
+	}
+
+	// Ask for confirmation.
+
+	if !options.Yes {
+		err := askForConfirmation(ctx, filteredArchives)
+		if err != nil {
+			return err
+		}
 	}
+
+	// Install teeth.
+
+	log.Info("Installing teeth...")
+
+	// Archives are resolved from specifiers in order.
+	specifierStrings := make(map[string]string)
+	for i, archive := range specifiedArchives {
+		specifierStrings[archive.Metadata().ToothRepoPath()] = specifiers[i].String()
+	}
+
+	// Tell where existing destinations were moved even if installation fails.
+	defer logBackup(conflictResolver)
+
+	for _, archive := range filteredArchives {
+		if err := installToothArchive(ctx, installedStore, archive,
+			specifierStrings[archive.Metadata().ToothRepoPath()], conflictResolver, options.ForceReinstall,
+			options.Upgrade, options.AllowScripts, options.IgnoreScripts); err != nil {
+			return fmt.Errorf("failed to install tooth archive %v\n\t%w", archive.FilePath().LocalString(), err)
+		}
+	}
+
+	return nil
+}
+
+// FetchSpecifiers downloads the tooth archives and assets of specifiers into the
+// cache without installing them. toothArchiveURLs is as in Options. It returns the
+// URLs the tooth archives were fetched from, by tooth repo path, so that installing
+// them later finds them in the cache.
+func FetchSpecifiers(ctx *context.Context, specifiers []specifier.Specifier,
+	toothArchiveURLs map[string]string) (map[string]string, error) {
+
+	archives, err := resolveSpecifiers(ctx, specifiers, toothArchiveURLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse and download specifier string list\n\t%w", err)
+	}
+
+	fetchedURLs := make(map[string]string)
+	for _, archive := range archives {
+		if err := downloadToothAssetArchiveIfNotCached(ctx, archive); err != nil {
+			return nil, fmt.Errorf("failed to download tooth assets\n\t%w", err)
+		}
+
+		if archive.SourceURL() != nil {
+			fetchedURLs[archive.Metadata().ToothRepoPath()] = archive.SourceURL().String()
+		}
+	}
+
+	return fetchedURLs, nil
 }
 
 // askForConfirmation asks for confirmation before installing the tooth.
@@ -224,12 +373,71 @@ func askForConfirmation(ctx *context.Context,
 	}
 
 	// Ask for confirmation.
-	log.Info("Do you want to continue? [y/N]")
-	var ans string
-	fmt.Scanln(&ans)
-	if ans != "y" && ans != "Y" {
+	ok, err := prompt.Confirm(ctx, "Do you want to continue?", false, "--yes")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
 		return fmt.Errorf("aborted")
 	}
 
 	return nil
 }
+
+// checkExecutableNames checks that no two teeth declare the same executable, as
+// their shims share the bin directory. Installed teeth replaced by the teeth of
+// metadataToInstall are not taken into account.
+func checkExecutableNames(installedStore *tooth.InstalledStore, metadataToInstall []tooth.Metadata) error {
+	replaced := make(map[string]bool)
+	for _, metadata := range metadataToInstall {
+		replaced[metadata.ToothRepoPath()] = true
+	}
+
+	metadataList := make([]tooth.Metadata, 0)
+	for _, metadata := range installedStore.All() {
+		if !replaced[metadata.ToothRepoPath()] {
+			metadataList = append(metadataList, metadata)
+		}
+	}
+
+	metadataList = append(metadataList, metadataToInstall...)
+
+	declaredBy := make(map[string]string)
+	for _, metadata := range metadataList {
+		bin, err := metadata.Bin()
+		if err != nil {
+			return fmt.Errorf("failed to get executables of tooth %v\n\t%w", metadata.ToothRepoPath(), err)
+		}
+
+		for name := range bin {
+			if toothRepoPath, ok := declaredBy[name]; ok {
+				return fmt.Errorf("executable %v is declared by both tooth %v and tooth %v", name, toothRepoPath,
+					metadata.ToothRepoPath())
+			}
+
+			declaredBy[name] = metadata.ToothRepoPath()
+		}
+	}
+
+	return nil
+}
+
+// logBackup tells where existing destinations were moved, if any.
+func logBackup(conflictResolver *install.ConflictResolver) {
+	if backup := conflictResolver.Backup(); backup != nil && len(backup.Entries) != 0 {
+		log.Infof("Existing destinations were moved into backup %v. Run \"lip backup restore %v\" to restore them",
+			backup.ID, backup.ID)
+	}
+}
+
+// getTransactionCommand returns the command recorded in the journal. Only the
+// specifiers are included, as other options may contain secrets.
+func getTransactionCommand(specifiers []specifier.Specifier) string {
+	specifierStrings := make([]string, 0, len(specifiers))
+	for _, specifier := range specifiers {
+		specifierStrings = append(specifierStrings, specifier.String())
+	}
+
+	return "install " + strings.Join(specifierStrings, " ")
+}
@@ -10,17 +10,12 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func getFixedToothAndVersionMap(ctx *context.Context, specifiedArchives []tooth.Archive, upgradeFlag bool,
+func getFixedToothAndVersionMap(installedStore *tooth.InstalledStore, specifiedArchives []tooth.Archive, upgradeFlag bool,
 	forceReinstallFlag bool) (map[string]semver.Version, error) {
 
 	fixedTeethAndVersions := make(map[string]semver.Version)
 
-	installedToothMetadataList, err := tooth.GetAllMetadata(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all installed tooth metadata\n\t%w", err)
-	}
-
-	for _, installedToothMetadata := range installedToothMetadataList {
+	for _, installedToothMetadata := range installedStore.All() {
 		fixedTeethAndVersions[installedToothMetadata.ToothRepoPath()] = installedToothMetadata.Version()
 	}
 
@@ -52,14 +47,14 @@ func getFixedToothAndVersionMap(ctx *context.Context, specifiedArchives []tooth.
 // specifier and returns the paths to the downloaded teeth. rootArchiveList
 // contains the root tooth archives to resolve dependencies.
 // The first return value indicates whether the dependencies are resolved.
-func resolveDependencies(ctx *context.Context, rootArchiveList []tooth.Archive,
+func resolveDependencies(ctx *context.Context, installedStore *tooth.InstalledStore, rootArchiveList []tooth.Archive,
 	upgradeFlag bool, forceReinstallFlag bool) ([]tooth.Archive, error) {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "cmdlipinstall",
 		"method":  "resolveDependencies",
 	})
 
-	fixedToothAndVersionMap, err := getFixedToothAndVersionMap(ctx, rootArchiveList, upgradeFlag,
+	fixedToothAndVersionMap, err := getFixedToothAndVersionMap(installedStore, rootArchiveList, upgradeFlag,
 		forceReinstallFlag)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get fixed tooth and version map\n\t%w", err)
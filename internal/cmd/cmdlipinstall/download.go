@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"github.com/blang/semver/v4"
+	"github.com/lippkg/lip/internal/cache"
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/network"
 	"github.com/lippkg/lip/internal/path"
@@ -14,43 +15,74 @@ import (
 	"golang.org/x/mod/module"
 )
 
-func downloadFileIfNotCached(ctx *context.Context, downloadURL *url.URL) (path.Path, error) {
+// downloadFileIfNotCached downloads a file into the cache if it is not cached, and
+// returns the path to the cached file. toothRepoPath and toothVersion identify the
+// tooth the file is downloaded for.
+func downloadFileIfNotCached(ctx *context.Context, downloadURL *url.URL, toothRepoPath string,
+	toothVersion semver.Version) (path.Path, error) {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "cmdlipinstall",
 		"method":  "downloadFileIfNotCached",
 	})
 
-	cachePath, err := getCachePath(ctx, downloadURL)
+	c, err := openCache(ctx)
 	if err != nil {
-		return path.Path{}, fmt.Errorf("failed to get cache path of %v\n\t%w", downloadURL, err)
+		return path.Path{}, err
 	}
 
-	// Skip downloading if the file is already in the cache.
-	if _, err := os.Stat(cachePath.LocalString()); os.IsNotExist(err) {
-		log.Infof("Downloading %v", downloadURL)
-
-		var enableProgressBar bool
-		if log.GetLevel() == log.PanicLevel || log.GetLevel() == log.FatalLevel ||
-			log.GetLevel() == log.ErrorLevel || log.GetLevel() == log.WarnLevel {
-			enableProgressBar = false
-		} else {
-			enableProgressBar = true
-		}
+	// Concurrent downloads of the same URL wait for each other.
+	entryLock, err := c.LockEntry(downloadURL)
+	if err != nil {
+		return path.Path{}, err
+	}
+	defer entryLock.Release()
 
-		proxyURL, err := ctx.ProxyURL()
-		if err != nil {
-			return path.Path{}, fmt.Errorf("failed to get proxy URL\n\t%w", err)
-		}
+	// Skip downloading if the file is already in the cache.
+	cachePath, cached, err := c.Lookup(downloadURL)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to look up %v in the cache\n\t%w", downloadURL, err)
+	} else if cached {
+		debugLogger.Debugf("File %v already exists in the cache, skip downloading", cachePath.LocalString())
+		return cachePath, nil
+	}
 
-		if err := network.DownloadFile(downloadURL, proxyURL, cachePath, enableProgressBar); err != nil {
-			return path.Path{}, fmt.Errorf("failed to download file\n\t%w", err)
-		}
+	log.Infof("Downloading %v", downloadURL)
 
-	} else if err != nil {
-		return path.Path{}, fmt.Errorf("failed to check if file exists\n\t%w", err)
+	var enableProgressBar bool
+	if log.GetLevel() == log.PanicLevel || log.GetLevel() == log.FatalLevel ||
+		log.GetLevel() == log.ErrorLevel || log.GetLevel() == log.WarnLevel {
+		enableProgressBar = false
 	} else {
-		debugLogger.Debugf("File %v already exists in the cache, skip downloading", cachePath.LocalString())
+		enableProgressBar = true
+	}
+
+	networkOptions, err := ctx.NetworkOptions()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to get network options\n\t%w", err)
+	}
+
+	tempFilePath, err := c.TempFilePath()
+	if err != nil {
+		return path.Path{}, err
 	}
+	defer os.Remove(tempFilePath.LocalString())
+
+	downloadedFile, err := network.DownloadFile(downloadURL, networkOptions, tempFilePath, enableProgressBar)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to download file\n\t%w", err)
+	}
+
+	cachePath, err = c.Add(downloadURL, tempFilePath, downloadedFile.ETag, downloadedFile.URL, toothRepoPath,
+		toothVersion.String())
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to add %v to the cache\n\t%w", downloadURL, err)
+	}
+
+	if downloadedFile.URL.String() != downloadURL.String() {
+		log.Infof("Downloaded %v from %v", downloadURL, downloadedFile.URL)
+	}
+
+	debugLogger.Debugf("Cached %v at %v", downloadURL, cachePath.LocalString())
 
 	return cachePath, nil
 }
@@ -59,11 +91,6 @@ func downloadFileIfNotCached(ctx *context.Context, downloadURL *url.URL) (path.P
 // if it is not cached, and returns the path to the downloaded tooth archive.
 func downloadToothArchiveIfNotCached(ctx *context.Context, toothRepoPath string,
 	toothVersion semver.Version) (tooth.Archive, error) {
-	debugLogger := log.WithFields(log.Fields{
-		"package": "cmdlipinstall",
-		"method":  "downloadToothArchiveIfNotCached",
-	})
-
 	goModuleProxyURL, err := ctx.GoModuleProxyURL()
 	if err != nil {
 		return tooth.Archive{}, fmt.Errorf("failed to get Go module proxy URL\n\t%w", err)
@@ -74,7 +101,19 @@ func downloadToothArchiveIfNotCached(ctx *context.Context, toothRepoPath string,
 		return tooth.Archive{}, fmt.Errorf("failed to generate Go module zip file URL\n\t%w", err)
 	}
 
-	cachePath, err := downloadFileIfNotCached(ctx, downloadURL)
+	return downloadToothArchiveFromURLIfNotCached(ctx, downloadURL, toothRepoPath, toothVersion)
+}
+
+// downloadToothArchiveFromURLIfNotCached downloads the tooth archive from downloadURL
+// if it is not cached, and returns the path to the downloaded tooth archive.
+func downloadToothArchiveFromURLIfNotCached(ctx *context.Context, downloadURL *url.URL, toothRepoPath string,
+	toothVersion semver.Version) (tooth.Archive, error) {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "cmdlipinstall",
+		"method":  "downloadToothArchiveFromURLIfNotCached",
+	})
+
+	cachePath, err := downloadFileIfNotCached(ctx, downloadURL, toothRepoPath, toothVersion)
 	if err != nil {
 		return tooth.Archive{}, fmt.Errorf("failed to download file\n\t%w", err)
 	}
@@ -90,6 +129,8 @@ func downloadToothArchiveIfNotCached(ctx *context.Context, toothRepoPath string,
 		return tooth.Archive{}, fmt.Errorf("failed to validate archive\n\t%w", err)
 	}
 
+	archive = archive.ToSourceURLAttached(downloadURL)
+
 	debugLogger.Debugf("Downloaded tooth archive %v", cachePath.LocalString())
 
 	return archive, nil
@@ -97,80 +138,82 @@ func downloadToothArchiveIfNotCached(ctx *context.Context, toothRepoPath string,
 
 func downloadToothAssetArchiveIfNotCached(ctx *context.Context, archive tooth.Archive) error {
 	metadata := archive.Metadata()
-	assetURL, err := metadata.AssetURL()
+
+	downloadURL, err := getAssetDownloadURL(ctx, metadata)
 	if err != nil {
-		return fmt.Errorf("failed to get asset URL\n\t%w", err)
+		return err
 	}
 
-	if assetURL.String() == "" {
+	if downloadURL == nil {
 		return nil
 	}
 
-	// Rewrite GitHub URL to GitHub mirror URL if it is set.
-
-	gitHubMirrorURL, err := ctx.GitHubMirrorURL()
-	if err != nil {
-		return fmt.Errorf("failed to get GitHub mirror URL\n\t%w", err)
+	if _, err := downloadFileIfNotCached(ctx, downloadURL, metadata.ToothRepoPath(), metadata.Version()); err != nil {
+		return fmt.Errorf("failed to download file\n\t%w", err)
 	}
 
-	if network.IsGitHubDirectDownloadURL(assetURL) {
-		// HTTP or HTTPS URL from GitHub.
-
-		mirroredURL, err := network.GenerateGitHubMirrorURL(assetURL, gitHubMirrorURL)
-		if err != nil {
-			return fmt.Errorf("failed to generate GitHub mirror URL\n\t%w", err)
-		}
+	return nil
+}
 
-		if _, err := downloadFileIfNotCached(ctx, mirroredURL); err != nil {
-			return fmt.Errorf("failed to download file\n\t%w", err)
-		}
+// getAssetDownloadURL returns the URL to download the asset of a tooth from and to
+// cache it by, or nil if the tooth has no asset. Mirrors are applied by URL rewrite
+// rules when downloading.
+func getAssetDownloadURL(ctx *context.Context, metadata tooth.Metadata) (*url.URL, error) {
+	assetURL, err := metadata.AssetURL()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset URL\n\t%w", err)
+	}
 
-	} else if assetURL.Scheme == "http" || assetURL.Scheme == "https" {
-		// Other HTTP or HTTPS URL.
+	if assetURL.String() == "" {
+		return nil, nil
+	}
 
-		if _, err := downloadFileIfNotCached(ctx, assetURL); err != nil {
-			return fmt.Errorf("failed to download file\n\t%w", err)
-		}
+	if assetURL.Scheme == "http" || assetURL.Scheme == "https" {
+		return assetURL, nil
 
 	} else if err := module.CheckPath(assetURL.String()); err == nil {
 		// Go module path.
 
 		goModuleProxyURL, err := ctx.GoModuleProxyURL()
 		if err != nil {
-			return fmt.Errorf("failed to get Go module proxy URL\n\t%w", err)
+			return nil, fmt.Errorf("failed to get Go module proxy URL\n\t%w", err)
 		}
 
-		downloadURL, err := network.GenerateGoModuleZipFileURL(assetURL.String(), archive.Metadata().Version(), goModuleProxyURL)
+		downloadURL, err := network.GenerateGoModuleZipFileURL(assetURL.String(), metadata.Version(), goModuleProxyURL)
 		if err != nil {
-			return fmt.Errorf("failed to generate Go module zip file URL\n\t%w", err)
+			return nil, fmt.Errorf("failed to generate Go module zip file URL\n\t%w", err)
 		}
 
-		if _, err := downloadFileIfNotCached(ctx, downloadURL); err != nil {
-			return fmt.Errorf("failed to download file\n\t%w", err)
-		}
+		return downloadURL, nil
 
 	} else {
-		return fmt.Errorf("unsupported asset URL: %v", assetURL)
+		return nil, fmt.Errorf("unsupported asset URL: %v", assetURL)
 	}
-
-	return nil
 }
 
+// getCachePath returns the path of the cached file of a URL. The file must have
+// been downloaded.
 func getCachePath(ctx *context.Context, u *url.URL) (path.Path, error) {
-	debugLogger := log.WithFields(log.Fields{
-		"package": "cmdlipinstall",
-		"method":  "getCachePath",
-	})
+	c, err := openCache(ctx)
+	if err != nil {
+		return path.Path{}, err
+	}
 
-	cacheDir, err := ctx.CacheDir()
+	cachePath, cached, err := c.Lookup(u)
 	if err != nil {
-		return path.Path{}, fmt.Errorf("failed to get cache directory\n\t%w", err)
+		return path.Path{}, fmt.Errorf("failed to look up %v in the cache\n\t%w", u, err)
+	} else if !cached {
+		return path.Path{}, fmt.Errorf("%v is not in the cache", u)
 	}
 
-	cacheFileName := url.QueryEscape(u.String())
-	cachePath := cacheDir.Join(path.MustParse(cacheFileName))
+	return cachePath, nil
+}
 
-	debugLogger.Debugf("Cache path of %v is %v", u, cachePath.LocalString())
+func openCache(ctx *context.Context) (*cache.Cache, error) {
+	c, err := cache.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache\n\t%w", err)
+	}
 
-	return cachePath, nil
+	return c, nil
 }
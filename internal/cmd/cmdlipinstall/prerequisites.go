@@ -4,13 +4,12 @@ import (
 	"fmt"
 
 	"github.com/blang/semver/v4"
-	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/tooth"
 )
 
 // getMissingPrerequisites finds missing prerequisites of the tooth specified
 // by the specifier and returns the map of missing prerequisites.
-func getMissingPrerequisites(ctx *context.Context,
+func getMissingPrerequisites(installedStore *tooth.InstalledStore,
 	archiveList []tooth.Archive) (map[string]semver.Range, map[string]string, error) {
 	missingPrerequisiteMap := make(map[string]semver.Range)
 	missingPrerequisitesAsStrings := make(map[string]string)
@@ -24,13 +23,8 @@ func getMissingPrerequisites(ctx *context.Context,
 		prerequisitesAsStrings := archive.Metadata().PrerequisitesAsStrings()
 
 		for prerequisite, versionRange := range prerequisites {
-			isInstalled, err := tooth.IsInstalled(ctx, prerequisite)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
-			}
-
-			if isInstalled {
-				currentMetadata, err := tooth.GetMetadata(ctx, prerequisite)
+			if installedStore.IsInstalled(prerequisite) {
+				currentMetadata, err := installedStore.Get(prerequisite)
 				if err != nil {
 					return nil, nil, fmt.Errorf("failed to find installed tooth metadata\n\t%w", err)
 				}
@@ -0,0 +1,98 @@
+package cmdlipinstall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/tooth"
+)
+
+// makeProvenance records where a tooth archive with its asset attached came from.
+// assetDownloadURL is the URL the asset is cached by, or nil if the tooth archive
+// is the asset. specifier is the specifier typed by the user, or empty for
+// dependencies.
+func makeProvenance(ctx *context.Context, archive tooth.Archive, assetDownloadURL *url.URL,
+	specifier string) (tooth.Provenance, error) {
+
+	provenance := tooth.Provenance{
+		Specifier:  specifier,
+		ToothJSON:  archive.ToothJSON(),
+		LipVersion: ctx.LipVersion().String(),
+	}
+
+	toothArchiveDigest, err := getFileDigest(archive.FilePath())
+	if err != nil {
+		return tooth.Provenance{}, err
+	}
+
+	provenance.ToothArchiveDigest = toothArchiveDigest
+
+	if archive.SourceURL() != nil {
+		provenance.ToothArchiveURL = archive.SourceURL().String()
+
+		provenance.ToothArchiveSourceURL, err = getSourceURL(ctx, archive.SourceURL())
+		if err != nil {
+			return tooth.Provenance{}, err
+		}
+	}
+
+	if assetDownloadURL != nil {
+		assetFilePath, err := archive.AssetFilePath()
+		if err != nil {
+			return tooth.Provenance{}, fmt.Errorf("failed to get asset file path\n\t%w", err)
+		}
+
+		provenance.AssetDigest, err = getFileDigest(assetFilePath)
+		if err != nil {
+			return tooth.Provenance{}, err
+		}
+
+		provenance.AssetURL = assetDownloadURL.String()
+
+		provenance.AssetSourceURL, err = getSourceURL(ctx, assetDownloadURL)
+		if err != nil {
+			return tooth.Provenance{}, err
+		}
+	}
+
+	return provenance, nil
+}
+
+// ---------------------------------------------------------------------
+
+// getFileDigest returns the SHA-256 digest of a file in the form of sha256:<hex>.
+func getFileDigest(filePath path.Path) (string, error) {
+	file, err := os.Open(filePath.LocalString())
+	if err != nil {
+		return "", fmt.Errorf("failed to open %v\n\t%w", filePath.LocalString(), err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// getSourceURL returns the URL a cached file was downloaded from after rewriting.
+func getSourceURL(ctx *context.Context, u *url.URL) (string, error) {
+	c, err := openCache(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	entry, ok := c.Entry(u)
+	if !ok || entry.Source == "" {
+		return u.String(), nil
+	}
+
+	return entry.Source, nil
+}
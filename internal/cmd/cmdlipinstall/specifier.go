@@ -2,18 +2,21 @@ package cmdlipinstall
 
 import (
 	"fmt"
+	"net/url"
 
 	"github.com/blang/semver/v4"
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/must"
 	specifierpkg "github.com/lippkg/lip/internal/specifier"
 	"github.com/lippkg/lip/internal/tooth"
+	log "github.com/sirupsen/logrus"
 )
 
 // downloadToothRepoSpecifier downloads the tooth specified by the specifier and returns
-// the path to the downloaded tooth.
+// the path to the downloaded tooth. If toothArchiveURL is not empty and the version
+// is specified, the tooth archive is downloaded from it first.
 func downloadToothRepoSpecifier(ctx *context.Context,
-	specifier specifierpkg.Specifier) (tooth.Archive, error) {
+	specifier specifierpkg.Specifier, toothArchiveURL string) (tooth.Archive, error) {
 	if specifier.Kind() != specifierpkg.ToothRepoKind {
 		return tooth.Archive{}, fmt.Errorf("invalid specifier kind %v", specifier.Kind())
 	}
@@ -31,6 +34,20 @@ func downloadToothRepoSpecifier(ctx *context.Context,
 	if isToothVersionSpecified {
 		toothVersion = must.Must(specifier.ToothVersion())
 
+		if toothArchiveURL != "" {
+			downloadURL, err := url.Parse(toothArchiveURL)
+			if err == nil {
+				var archive tooth.Archive
+				archive, err = downloadToothArchiveFromURLIfNotCached(ctx, downloadURL, toothRepoPath, toothVersion)
+				if err == nil {
+					return archive, nil
+				}
+			}
+
+			log.Warnf("Failed to get tooth archive of %v@%v from %v. Trying the Go module proxy.\n\t%v",
+				toothRepoPath, toothVersion, toothArchiveURL, err)
+		}
+
 	} else {
 		latestVersion, err := tooth.GetLatestVersion(ctx, toothRepoPath)
 		if err != nil {
@@ -51,9 +68,10 @@ func downloadToothRepoSpecifier(ctx *context.Context,
 
 // resolveSpecifiers parses the specifier string list and
 // downloads the tooth specified by the specifier, and returns the list of
-// downloaded tooth archives.
+// downloaded tooth archives. toothArchiveURLs maps tooth repo paths to recorded
+// URLs of their tooth archives, and may be nil.
 func resolveSpecifiers(ctx *context.Context,
-	specifiers []specifierpkg.Specifier) ([]tooth.Archive, error) {
+	specifiers []specifierpkg.Specifier, toothArchiveURLs map[string]string) ([]tooth.Archive, error) {
 
 	archiveList := make([]tooth.Archive, 0)
 
@@ -71,7 +89,8 @@ func resolveSpecifiers(ctx *context.Context,
 			archive = localArchive
 
 		case specifierpkg.ToothRepoKind:
-			downloadedArchive, err := downloadToothRepoSpecifier(ctx, specifier)
+			downloadedArchive, err := downloadToothRepoSpecifier(ctx, specifier,
+				toothArchiveURLs[must.Must(specifier.ToothRepoPath())])
 			if err != nil {
 				return nil, fmt.Errorf("failed to download specifier %v\n\t%w", specifier, err)
 			}
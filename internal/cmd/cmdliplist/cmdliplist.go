@@ -29,6 +29,12 @@ func Command(ctx *context.Context) *cli.Command {
 				Usage:              "list upgradable teeth",
 				DisableDefaultText: true,
 			},
+			&cli.BoolFlag{
+				Name:               "global",
+				Aliases:            []string{"g"},
+				Usage:              "list teeth in the global workspace",
+				DisableDefaultText: true,
+			},
 		},
 		Action: func(cCtx *cli.Context) error {
 			// Check if there are unexpected arguments.
@@ -36,6 +42,12 @@ func Command(ctx *context.Context) *cli.Command {
 				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
 			}
 
+			if cCtx.Bool("global") {
+				if err := ctx.UseGlobalWorkspace(); err != nil {
+					return fmt.Errorf("failed to use global workspace\n\t%w", err)
+				}
+			}
+
 			if cCtx.Bool("upgradable") {
 				err := listUpgradable(ctx, cCtx.Bool("json"))
 				if err != nil {
@@ -60,11 +72,13 @@ func Command(ctx *context.Context) *cli.Command {
 // listAll lists all installed teeth.
 func listAll(ctx *context.Context, jsonFlag bool) error {
 
-	metadataList, err := tooth.GetAllMetadata(ctx)
+	installedStore, err := tooth.LoadInstalledStore(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to list all installed teeth\n\t%w", err)
 	}
 
+	metadataList := installedStore.All()
+
 	if jsonFlag {
 		// Marshal the data.
 		jsonBytes, err := json.Marshal(metadataList)
@@ -105,11 +119,13 @@ func listAll(ctx *context.Context, jsonFlag bool) error {
 // listUpgradable lists upgradable teeth.
 func listUpgradable(ctx *context.Context, jsonFlag bool) error {
 
-	metadataList, err := tooth.GetAllMetadata(ctx)
+	installedStore, err := tooth.LoadInstalledStore(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to list all installed teeth\n\t%w", err)
 	}
 
+	metadataList := installedStore.All()
+
 	if jsonFlag {
 		dataList := make([]tooth.Metadata, 0)
 
@@ -0,0 +1,254 @@
+package cmdliprollback
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/lippkg/lip/internal/cmd/cmdlipinstall"
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/install"
+	"github.com/lippkg/lip/internal/prompt"
+	"github.com/lippkg/lip/internal/specifier"
+	"github.com/lippkg/lip/internal/tooth"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const descriptionText = `
+Restore the teeth and versions installed before a transaction listed by
+"lip history". Teeth installed since are uninstalled, and teeth uninstalled or
+changed since are reinstalled at their previous versions. Tooth archives are
+taken from the cache or downloaded from the URLs recorded in the transaction.
+`
+
+func Command(ctx *context.Context) *cli.Command {
+	return &cli.Command{
+		Name:        "rollback",
+		Usage:       "restore the teeth installed before a transaction",
+		Description: descriptionText,
+		ArgsUsage:   "<transaction ID>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:               "yes",
+				Aliases:            []string{"y"},
+				Usage:              "skip confirmation",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "allow-scripts",
+				Usage:              "run commands declared by teeth without asking under the prompt script policy",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "ignore-scripts",
+				Usage:              "do not run commands declared by teeth",
+				DisableDefaultText: true,
+			},
+		},
+		Action: func(cCtx *cli.Context) error {
+			if cCtx.NArg() != 1 {
+				return fmt.Errorf("must give one transaction ID")
+			}
+
+			id, err := strconv.Atoi(cCtx.Args().Get(0))
+			if err != nil {
+				return fmt.Errorf("invalid transaction ID %v", cCtx.Args().Get(0))
+			}
+
+			workspaceLock, err := ctx.LockWorkspace()
+			if err != nil {
+				return err
+			}
+			defer workspaceLock.Release()
+
+			installedStore, err := tooth.LoadInstalledStore(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load installed teeth\n\t%w", err)
+			}
+
+			transaction, err := tooth.LoadTransaction(ctx, id)
+			if err != nil {
+				return fmt.Errorf("failed to load transaction\n\t%w", err)
+			}
+
+			toUninstall, toInstall := makePlan(installedStore, transaction.Before)
+
+			if len(toUninstall) == 0 && len(toInstall) == 0 {
+				log.Info("Installed teeth are already as before the transaction.")
+				return nil
+			}
+
+			if !cCtx.Bool("yes") {
+				err := askForConfirmation(ctx, installedStore, toUninstall, toInstall)
+				if err != nil {
+					return err
+				}
+			}
+
+			installedStore.BeginTransaction(fmt.Sprintf("rollback %v", id))
+
+			err = rollback(ctx, installedStore, toUninstall, toInstall, cCtx.Bool("allow-scripts"),
+				cCtx.Bool("ignore-scripts"))
+			installedStore.EndTransaction(err)
+			if err != nil {
+				return err
+			}
+
+			log.Info("Done.")
+
+			return nil
+		},
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// askForConfirmation asks for confirmation before rolling back.
+func askForConfirmation(ctx *context.Context, installedStore *tooth.InstalledStore, toUninstall []string,
+	toInstall []tooth.TransactionTooth) error {
+
+	if len(toUninstall) != 0 {
+		log.Info("The following teeth will be uninstalled:")
+		for _, toothRepoPath := range toUninstall {
+			metadata, err := installedStore.Get(toothRepoPath)
+			if err != nil {
+				return fmt.Errorf("failed to get installed tooth metadata\n\t%w", err)
+			}
+
+			log.Infof("  %v@%v", toothRepoPath, metadata.Version())
+		}
+	}
+
+	if len(toInstall) != 0 {
+		log.Info("The following teeth will be installed:")
+		for _, transactionTooth := range toInstall {
+			log.Infof("  %v@%v", transactionTooth.Tooth, transactionTooth.Version)
+		}
+	}
+
+	// Ask for confirmation.
+	ok, err := prompt.Confirm(ctx, "Do you want to continue?", false, "--yes")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("aborted")
+	}
+
+	return nil
+}
+
+// makePlan compares the installed teeth with the target teeth. It returns the teeth
+// to uninstall and the teeth to install or reinstall at the target versions.
+func makePlan(installedStore *tooth.InstalledStore, target []tooth.TransactionTooth) ([]string,
+	[]tooth.TransactionTooth) {
+
+	targetMap := make(map[string]tooth.TransactionTooth)
+	for _, transactionTooth := range target {
+		targetMap[transactionTooth.Tooth] = transactionTooth
+	}
+
+	toUninstall := make([]string, 0)
+	for _, metadata := range installedStore.All() {
+		if _, ok := targetMap[metadata.ToothRepoPath()]; !ok {
+			toUninstall = append(toUninstall, metadata.ToothRepoPath())
+		}
+	}
+
+	toInstall := make([]tooth.TransactionTooth, 0)
+	for _, transactionTooth := range target {
+		metadata, err := installedStore.Get(transactionTooth.Tooth)
+		if err != nil || metadata.Version().String() != transactionTooth.Version {
+			toInstall = append(toInstall, transactionTooth)
+		}
+	}
+
+	sort.Strings(toUninstall)
+	sort.Slice(toInstall, func(i, j int) bool {
+		return toInstall[i].Tooth < toInstall[j].Tooth
+	})
+
+	return toUninstall, toInstall
+}
+
+// rollback uninstalls and installs teeth as planned, then restores the install
+// reasons of the installed teeth. Tooth archives are fetched from their recorded
+// URLs when available, before anything is uninstalled.
+func rollback(ctx *context.Context, installedStore *tooth.InstalledStore, toUninstall []string,
+	toInstall []tooth.TransactionTooth, allowScripts bool, ignoreScripts bool) error {
+
+	specifiers := make([]specifier.Specifier, 0, len(toInstall))
+	toothArchiveURLs := make(map[string]string)
+	for _, transactionTooth := range toInstall {
+		if transactionTooth.ToothArchiveURL != "" {
+			toothArchiveURLs[transactionTooth.Tooth] = transactionTooth.ToothArchiveURL
+		} else {
+			log.Warnf("Tooth %v was not installed from a tooth repository, or was installed by an older version of lip. Trying its repository.",
+				transactionTooth.Tooth)
+		}
+
+		specifier, err := specifier.Parse(fmt.Sprintf("%v@%v", transactionTooth.Tooth, transactionTooth.Version))
+		if err != nil {
+			return fmt.Errorf("failed to parse specifier\n\t%w", err)
+		}
+
+		specifiers = append(specifiers, specifier)
+	}
+
+	// A failed download must not leave the teeth half rolled back.
+	if len(specifiers) != 0 {
+		log.Info("Downloading teeth...")
+
+		fetchedURLs, err := cmdlipinstall.FetchSpecifiers(ctx, specifiers, toothArchiveURLs)
+		if err != nil {
+			return fmt.Errorf("failed to download teeth\n\t%w", err)
+		}
+
+		toothArchiveURLs = fetchedURLs
+	}
+
+	for _, toothRepoPath := range toUninstall {
+		log.Infof("Uninstalling tooth %v", toothRepoPath)
+
+		err := install.Uninstall(ctx, installedStore, toothRepoPath, allowScripts, ignoreScripts)
+		if err != nil {
+			return fmt.Errorf("failed to uninstall tooth %v\n\t%w", toothRepoPath, err)
+		}
+	}
+
+	if len(toInstall) == 0 {
+		return nil
+	}
+
+	// The rollback has been confirmed, so installing asks no more. The installed
+	// teeth are the target teeth after uninstalling, so dependencies need not be
+	// resolved.
+	err := cmdlipinstall.InstallSpecifiers(ctx, installedStore, specifiers, cmdlipinstall.Options{
+		ForceReinstall:   true,
+		NoDependencies:   true,
+		Yes:              true,
+		AllowScripts:     allowScripts,
+		IgnoreScripts:    ignoreScripts,
+		OnConflict:       install.OnConflictBackup,
+		ToothArchiveURLs: toothArchiveURLs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to install teeth\n\t%w", err)
+	}
+
+	for _, transactionTooth := range toInstall {
+		installedTooth, ok := installedStore.Lookup(transactionTooth.Tooth)
+		if !ok || installedTooth.Reason == transactionTooth.Reason {
+			continue
+		}
+
+		installedTooth.Reason = transactionTooth.Reason
+		if err := installedStore.Put(installedTooth); err != nil {
+			return fmt.Errorf("failed to restore install reason of tooth %v\n\t%w", transactionTooth.Tooth, err)
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/lippkg/lip/internal/context"
 	"github.com/urfave/cli/v2"
@@ -46,38 +47,18 @@ func Command(ctx *context.Context) *cli.Command {
 	}
 }
 
-// checkIsInstalledAndGetMetadata checks if the tooth is installed and returns
-// its metadata.
-func checkIsInstalledAndGetMetadata(ctx *context.Context,
-	toothRepoPath string) (bool, tooth.Metadata, error) {
-
-	isInstalled, err := tooth.IsInstalled(ctx, toothRepoPath)
-	if err != nil {
-		return false, tooth.Metadata{},
-			fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
-	}
-
-	if isInstalled {
-		metadata, err := tooth.GetMetadata(ctx, toothRepoPath)
-		if err != nil {
-			return false, tooth.Metadata{},
-				fmt.Errorf("failed to find installed tooth metadata\n\t%w", err)
-		}
-
-		return true, metadata, nil
-	} else {
-		return false, tooth.Metadata{}, nil
-	}
-}
-
 func show(ctx *context.Context, toothRepoPath string,
 	availableFlag bool, jsonFlag bool) error {
 
-	isInstalled, metadata, err := checkIsInstalledAndGetMetadata(ctx, toothRepoPath)
+	installedStore, err := tooth.LoadInstalledStore(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to load installed teeth\n\t%w", err)
 	}
 
+	installedTooth, isInstalled := installedStore.Lookup(toothRepoPath)
+	metadata := installedTooth.Metadata
+	provenance := installedTooth.Provenance
+
 	availableVersions := make([]string, 0)
 	if availableFlag {
 		versionList, err := tooth.GetAvailableVersions(ctx, toothRepoPath)
@@ -99,6 +80,10 @@ func show(ctx *context.Context, toothRepoPath string,
 
 		if isInstalled {
 			info["metadata"] = metadata
+			info["reason"] = installedTooth.Reason
+			info["installed_at"] = installedTooth.InstalledAt
+			info["updated_at"] = installedTooth.UpdatedAt
+			info["provenance"] = provenance
 		}
 
 		if availableFlag {
@@ -123,6 +108,15 @@ func show(ctx *context.Context, toothRepoPath string,
 				{"Author", metadata.Info().Author},
 				{"Tags", strings.Join(metadata.Info().Tags, ", ")},
 				{"Version", metadata.Version().String()},
+				{"Reason", installedTooth.Reason},
+				{"Installed At", formatTime(installedTooth.InstalledAt)},
+				{"Updated At", formatTime(installedTooth.UpdatedAt)},
+				{"Specifier", provenance.Specifier},
+				{"Tooth Archive URL", formatSourceURL(provenance.ToothArchiveURL, provenance.ToothArchiveSourceURL)},
+				{"Tooth Archive Digest", provenance.ToothArchiveDigest},
+				{"Asset URL", formatSourceURL(provenance.AssetURL, provenance.AssetSourceURL)},
+				{"Asset Digest", provenance.AssetDigest},
+				{"Installed By", formatLipVersion(provenance.LipVersion)},
 			}...)
 		}
 
@@ -146,3 +140,33 @@ func show(ctx *context.Context, toothRepoPath string,
 
 	return nil
 }
+
+// ---------------------------------------------------------------------
+
+// formatLipVersion formats the version of lip that installed a tooth.
+func formatLipVersion(lipVersion string) string {
+	if lipVersion == "" {
+		return ""
+	}
+
+	return "lip " + lipVersion
+}
+
+// formatSourceURL formats a URL together with the URL it was downloaded from after
+// rewriting, if they differ.
+func formatSourceURL(urlStr string, sourceURLStr string) string {
+	if sourceURLStr == "" || sourceURLStr == urlStr {
+		return urlStr
+	}
+
+	return fmt.Sprintf("%v (from %v)", urlStr, sourceURLStr)
+}
+
+// formatTime formats a time in the local time zone. Unknown times are empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
@@ -1,12 +1,12 @@
 package cmdliptoothinit
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/prompt"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
@@ -30,6 +30,24 @@ func Command(ctx *context.Context) *cli.Command {
 		Name:        "init",
 		Usage:       "initialize and writes a new tooth.json file in the current directory",
 		Description: "Initialize and writes a new tooth.json file in the current directory, in effect creating a new tooth rooted at the current directory.",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "tooth",
+				Usage: "the tooth repo path, asked for if not given",
+			},
+			&cli.StringFlag{
+				Name:  "name",
+				Usage: "the name, asked for if not given",
+			},
+			&cli.StringFlag{
+				Name:  "description",
+				Usage: "the description, asked for if not given",
+			},
+			&cli.StringFlag{
+				Name:  "author",
+				Usage: "the author, asked for if not given",
+			},
+		},
 		Action: func(cCtx *cli.Context) error {
 
 			// Check if there are unexpected arguments.
@@ -37,7 +55,7 @@ func Command(ctx *context.Context) *cli.Command {
 				return fmt.Errorf("unexpected arguments: %v", cCtx.Args())
 			}
 
-			if err := initTooth(ctx); err != nil {
+			if err := initTooth(ctx, cCtx); err != nil {
 				return fmt.Errorf("failed to initialize the tooth\n\t%w", err)
 			}
 
@@ -48,45 +66,52 @@ func Command(ctx *context.Context) *cli.Command {
 
 // ---------------------------------------------------------------------
 
-// initTooth initializes a new tooth in the current directory.
-func initTooth(ctx *context.Context) error {
+// initTooth initializes a new tooth in the current directory. Information not given
+// by flags is asked for.
+func initTooth(ctx *context.Context, cCtx *cli.Context) error {
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	toothJSONPath := workspaceDir.Join(path.MustParse("tooth.json"))
 
 	// Check if tooth.json already exists.
-	_, err := os.Stat("tooth.json")
-	if err == nil {
+	if _, err := os.Stat(toothJSONPath.LocalString()); err == nil {
 		return fmt.Errorf("tooth.json already exists")
 	}
 
 	rawMetadata := metadataTemplate
 
 	// Ask for information.
-	var ans string
-	scanner := bufio.NewScanner(os.Stdin)
-
-	log.Info("What is the tooth repo path? (e.g. github.com/tooth-hub/llbds3)")
-	scanner.Scan()
-	ans = scanner.Text()
+	toothRepoPath, err := getAnswer(ctx, cCtx, "tooth",
+		"What is the tooth repo path? (e.g. github.com/tooth-hub/llbds3)")
+	if err != nil {
+		return err
+	}
 
-	if !tooth.IsValidToothRepoPath(ans) {
-		return fmt.Errorf("invalid tooth repo path %v\n\t%w", ans, err)
+	if !tooth.IsValidToothRepoPath(toothRepoPath) {
+		return fmt.Errorf("invalid tooth repo path %v", toothRepoPath)
 	}
 
-	rawMetadata.Tooth = ans
+	rawMetadata.Tooth = toothRepoPath
 
-	log.Info("What is the name?")
-	scanner.Scan()
-	ans = scanner.Text()
-	rawMetadata.Info.Name = ans
+	rawMetadata.Info.Name, err = getAnswer(ctx, cCtx, "name", "What is the name?")
+	if err != nil {
+		return err
+	}
 
-	log.Info("What is the description?")
-	scanner.Scan()
-	ans = scanner.Text()
-	rawMetadata.Info.Description = ans
+	rawMetadata.Info.Description, err = getAnswer(ctx, cCtx, "description", "What is the description?")
+	if err != nil {
+		return err
+	}
 
-	log.Info("What is the author? Please input your GitHub username.")
-	scanner.Scan()
-	ans = scanner.Text()
-	rawMetadata.Info.Author = ans
+	rawMetadata.Info.Author, err = getAnswer(ctx, cCtx, "author",
+		"What is the author? Please input your GitHub username.")
+	if err != nil {
+		return err
+	}
 
 	metadata, err := tooth.MakeMetadataFromRaw(rawMetadata)
 	if err != nil {
@@ -99,17 +124,7 @@ func initTooth(ctx *context.Context) error {
 	}
 
 	// Create tooth.json.
-	workspaceDirStr, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
-	}
-
-	workspaceDir, err := path.Parse(workspaceDirStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse workspace directory\n\t%w", err)
-	}
-
-	file, err := os.Create(workspaceDir.Join(path.MustParse("tooth.json")).LocalString())
+	file, err := os.Create(toothJSONPath.LocalString())
 	if err != nil {
 		return fmt.Errorf("failed to create tooth.json\n\t%w", err)
 	}
@@ -124,3 +139,12 @@ func initTooth(ctx *context.Context) error {
 
 	return nil
 }
+
+// getAnswer returns the value of flag if given, or asks question otherwise.
+func getAnswer(ctx *context.Context, cCtx *cli.Context, flag string, question string) (string, error) {
+	if cCtx.IsSet(flag) {
+		return cCtx.String(flag), nil
+	}
+
+	return prompt.Ask(ctx, question, "--"+flag)
+}
@@ -83,8 +83,8 @@ func copyFile(sourcePath, destinationPath path.Path) error {
 	return nil
 }
 
-// packFilesToTemp packs files to a temporary zip file.
-func packFilesToTemp(fileList []path.Path) (path.Path, error) {
+// packFilesToTemp packs files relative to dir to a temporary zip file.
+func packFilesToTemp(dir path.Path, fileList []path.Path) (path.Path, error) {
 	zipFile, err := os.CreateTemp("", "*")
 	if err != nil {
 		return path.Path{}, fmt.Errorf("failed to create a temporary zip file\n\t%w", err)
@@ -113,7 +113,7 @@ func packFilesToTemp(fileList []path.Path) (path.Path, error) {
 			return path.Path{}, fmt.Errorf("failed to create %v in zip file\n\t%w", file.String(), err)
 		}
 
-		reader, err := os.Open(file.LocalString())
+		reader, err := os.Open(dir.Join(file).LocalString())
 		if err != nil {
 			return path.Path{}, fmt.Errorf("failed to open %v\n\t%w", file.LocalString(), err)
 		}
@@ -139,23 +139,18 @@ func packTooth(ctx *context.Context, outputPath path.Path) error {
 		return fmt.Errorf("failed to stat output path %v\n\t%w", outputPath.LocalString(), err)
 	}
 
-	workspaceDirStr, err := os.Getwd()
+	workspaceDir, err := ctx.WorkspaceDir()
 	if err != nil {
 		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
 	}
 
-	workspaceDir, err := path.Parse(workspaceDirStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse workspace directory\n\t%w", err)
-	}
-
 	fileList, err := walkDirectory(workspaceDir)
 	if err != nil {
 		return fmt.Errorf("failed to walk through the current directory\n\t%w", err)
 	}
 
 	// Pack files to a temporary zip file.
-	zipFilePath, err := packFilesToTemp(fileList)
+	zipFilePath, err := packFilesToTemp(workspaceDir, fileList)
 	if err != nil {
 		return fmt.Errorf("failed to pack files to a temporary zip file\n\t%w", err)
 	}
@@ -173,17 +168,12 @@ func packTooth(ctx *context.Context, outputPath path.Path) error {
 // validateToothJSON validates tooth.json.
 func validateToothJSON(ctx *context.Context) error {
 
-	workspaceDirStr, err := os.Getwd()
+	workspaceDir, err := ctx.WorkspaceDir()
 	if err != nil {
 		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
 	}
 
-	workspaceDir, err := path.Parse(workspaceDirStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse workspace directory\n\t%w", err)
-	}
-
-	jsonBytes, err := os.ReadFile(workspaceDir.Join(path.MustParse("tooth.json")).String())
+	jsonBytes, err := os.ReadFile(workspaceDir.Join(path.MustParse("tooth.json")).LocalString())
 	if err != nil {
 		return fmt.Errorf("failed to read tooth.json\n\t%w", err)
 	}
@@ -195,7 +185,7 @@ func validateToothJSON(ctx *context.Context) error {
 	return nil
 }
 
-// walkDirectory walks the directory and returns a list of files.
+// walkDirectory walks the directory and returns a list of files relative to it.
 func walkDirectory(dir path.Path) ([]path.Path, error) {
 
 	ignoredDirNames := []string{
@@ -204,7 +194,12 @@ func walkDirectory(dir path.Path) ([]path.Path, error) {
 	}
 
 	fileList := make([]path.Path, 0)
-	err := filepath.WalkDir(".", func(pathStr string, d os.DirEntry, err error) error {
+	err := filepath.WalkDir(dir.LocalString(), func(pathStr string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPathStr, err := filepath.Rel(dir.LocalString(), pathStr)
 		if err != nil {
 			return err
 		}
@@ -217,7 +212,7 @@ func walkDirectory(dir path.Path) ([]path.Path, error) {
 		}
 
 		if !d.IsDir() {
-			path, err := path.Parse(pathStr)
+			path, err := path.Parse(relPathStr)
 			if err != nil {
 				return fmt.Errorf("failed to parse path\n\t%w", err)
 			}
@@ -2,9 +2,11 @@ package cmdlipuninstall
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/install"
+	"github.com/lippkg/lip/internal/prompt"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
@@ -23,6 +25,22 @@ func Command(ctx *context.Context) *cli.Command {
 				Usage:              "skip confirmation",
 				DisableDefaultText: true,
 			},
+			&cli.BoolFlag{
+				Name:               "allow-scripts",
+				Usage:              "run commands declared by teeth without asking under the prompt script policy",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "ignore-scripts",
+				Usage:              "do not run commands declared by teeth",
+				DisableDefaultText: true,
+			},
+			&cli.BoolFlag{
+				Name:               "global",
+				Aliases:            []string{"g"},
+				Usage:              "uninstall from the global workspace",
+				DisableDefaultText: true,
+			},
 		},
 		Description: "Uninstall teeth.",
 		Action: func(cCtx *cli.Context) error {
@@ -31,18 +49,29 @@ func Command(ctx *context.Context) *cli.Command {
 				return fmt.Errorf("at least one specifier is required")
 			}
 
+			if cCtx.Bool("global") {
+				if err := ctx.UseGlobalWorkspace(); err != nil {
+					return fmt.Errorf("failed to use global workspace\n\t%w", err)
+				}
+			}
+
+			workspaceLock, err := ctx.LockWorkspace()
+			if err != nil {
+				return err
+			}
+			defer workspaceLock.Release()
+
+			installedStore, err := tooth.LoadInstalledStore(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load installed teeth\n\t%w", err)
+			}
+
 			toothRepoPathList := cCtx.Args().Slice()
 
 			// 1. Check if all teeth are installed.
 
 			for _, toothRepoPath := range toothRepoPathList {
-
-				isInstalled, err := tooth.IsInstalled(ctx, toothRepoPath)
-				if err != nil {
-					return fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
-				}
-
-				if !isInstalled {
+				if !installedStore.IsInstalled(toothRepoPath) {
 					return fmt.Errorf("tooth %v is not installed", toothRepoPath)
 				}
 			}
@@ -50,7 +79,7 @@ func Command(ctx *context.Context) *cli.Command {
 			// 2. Prompt for confirmation.
 
 			if !cCtx.Bool("yes") {
-				err := askForConfirmation(ctx, toothRepoPathList)
+				err := askForConfirmation(ctx, installedStore, toothRepoPathList)
 				if err != nil {
 					return err
 				}
@@ -58,13 +87,20 @@ func Command(ctx *context.Context) *cli.Command {
 
 			// 3. Uninstall all teeth.
 
+			installedStore.BeginTransaction("uninstall " + strings.Join(toothRepoPathList, " "))
+
 			for _, toothRepoPath := range toothRepoPathList {
-				err := install.Uninstall(ctx, toothRepoPath)
+				err := install.Uninstall(ctx, installedStore, toothRepoPath, cCtx.Bool("allow-scripts"),
+					cCtx.Bool("ignore-scripts"))
 				if err != nil {
-					return fmt.Errorf("failed to uninstall tooth %v\n\t%w", toothRepoPath, err)
+					err = fmt.Errorf("failed to uninstall tooth %v\n\t%w", toothRepoPath, err)
+					installedStore.EndTransaction(err)
+					return err
 				}
 			}
 
+			installedStore.EndTransaction(nil)
+
 			log.Info("Done.")
 
 			return nil
@@ -75,13 +111,13 @@ func Command(ctx *context.Context) *cli.Command {
 // ---------------------------------------------------------------------
 
 // askForConfirmation asks for confirmation before installing the tooth.
-func askForConfirmation(ctx *context.Context,
+func askForConfirmation(ctx *context.Context, installedStore *tooth.InstalledStore,
 	toothRepoPathList []string) error {
 
 	// Print the list of teeth to be installed.
 	log.Info("The following teeth will be uninstalled:")
 	for _, toothRepoPath := range toothRepoPathList {
-		metadata, err := tooth.GetMetadata(ctx, toothRepoPath)
+		metadata, err := installedStore.Get(toothRepoPath)
 		if err != nil {
 			return fmt.Errorf("failed to get installed tooth metadata\n\t%w", err)
 		}
@@ -91,10 +127,12 @@ func askForConfirmation(ctx *context.Context,
 	}
 
 	// Ask for confirmation.
-	log.Info("Do you want to continue? [y/N]")
-	var ans string
-	fmt.Scanln(&ans)
-	if ans != "y" && ans != "Y" {
+	ok, err := prompt.Confirm(ctx, "Do you want to continue?", false, "--yes")
+	if err != nil {
+		return err
+	}
+
+	if !ok {
 		return fmt.Errorf("aborted")
 	}
 
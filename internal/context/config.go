@@ -1,7 +1,489 @@
 package context
 
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/network"
+)
+
 type Config struct {
-	GitHubMirrorURL  string `json:"github_mirror_url"`
-	GoModuleProxyURL string `json:"go_module_proxy_url"`
-	ProxyURL         string `json:"proxy_url"`
+	GitHubMirrorURL  string   `json:"github_mirror_url"`
+	GoModuleProxyURL string   `json:"go_module_proxy_url"`
+	ProxyURL         string   `json:"proxy_url"`
+	NoProxy          []string `json:"no_proxy"`
+	ScriptPolicy     string   `json:"script_policy"`
+	HookTimeout      string   `json:"hook_timeout"`
+	LockTimeout      string   `json:"lock_timeout"`
+	HTTPCacheTTL     string   `json:"http_cache_ttl"`
+	TrustedTeeth     []string `json:"trusted_teeth"`
+	// Auth maps hosts, optionally with ports, to their credentials.
+	Auth map[string]network.HostAuth `json:"auth"`
+	// URLRewrites are applied in order to the URL of every request.
+	URLRewrites []network.RewriteRule `json:"url_rewrites"`
+	// TLS settings. Paths are of PEM files.
+	CABundle           string `json:"ca_bundle"`
+	ClientCert         string `json:"client_cert"`
+	ClientKey          string `json:"client_key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// Roots map names of install roots to directories relative to the workspace.
+	Roots map[string]string `json:"roots"`
+}
+
+// ConfigKind is the kind of value of a config key.
+type ConfigKind string
+
+const (
+	ConfigKindString   ConfigKind = "string"
+	ConfigKindBool     ConfigKind = "bool"
+	ConfigKindPath     ConfigKind = "path"
+	ConfigKindURL      ConfigKind = "url"
+	ConfigKindEnum     ConfigKind = "enum"
+	ConfigKindDuration ConfigKind = "duration"
+	ConfigKindList     ConfigKind = "list"
+	ConfigKindMap      ConfigKind = "map"
+)
+
+// ConfigKey describes a config key.
+type ConfigKey struct {
+	// Name is the name of the key in config files.
+	Name        string
+	Description string
+	Kind        ConfigKind
+	// Values are the allowed values of an enum key.
+	Values []string
+	// Optional allows an empty value for a URL key.
+	Optional bool
+	// Sensitive keys affect what lip trusts or runs. They are ignored in workspace
+	// config files, which may come with untrusted directories.
+	Sensitive bool
+
+	// validate checks the value further after its kind is checked.
+	validate func(value json.RawMessage) error
+}
+
+var configKeys = []ConfigKey{
+	{
+		Name:        "github_mirror_url",
+		Description: "URL of the GitHub mirror to download GitHub assets and archives from",
+		Kind:        ConfigKindURL,
+	},
+	{
+		Name:        "go_module_proxy_url",
+		Description: "URL of the Go module proxy to fetch tooth versions and archives from",
+		Kind:        ConfigKindURL,
+	},
+	{
+		Name: "proxy_url",
+		Description: "URL of the proxy, with scheme http, https, socks5 or socks5h, " +
+			"or empty to use HTTP_PROXY and HTTPS_PROXY",
+		Kind:     ConfigKindURL,
+		Optional: true,
+		validate: validateProxyURL,
+	},
+	{
+		Name:        "no_proxy",
+		Description: "hosts, domains, IP addresses or CIDR ranges never proxied, in addition to NO_PROXY",
+		Kind:        ConfigKindList,
+	},
+	{
+		Name:        "ca_bundle",
+		Description: "path of a PEM file of CA certificates trusted in addition to the system ones",
+		Kind:        ConfigKindPath,
+		Sensitive:   true,
+	},
+	{
+		Name:        "client_cert",
+		Description: "path of a PEM file of the client certificate for mutual TLS",
+		Kind:        ConfigKindPath,
+		Sensitive:   true,
+	},
+	{
+		Name:        "client_key",
+		Description: "path of a PEM file of the private key of client_cert",
+		Kind:        ConfigKindPath,
+		Sensitive:   true,
+	},
+	{
+		Name:        "insecure_skip_verify",
+		Description: "whether to skip verifying TLS certificates of servers, only for testing",
+		Kind:        ConfigKindBool,
+		Sensitive:   true,
+	},
+	{
+		Name:        "script_policy",
+		Description: "whether commands declared by teeth are run",
+		Kind:        ConfigKindEnum,
+		Values: []string{
+			ScriptPolicyAlways, ScriptPolicyNever, ScriptPolicyPrompt, ScriptPolicyTrustedAuthors,
+		},
+		Sensitive: true,
+	},
+	{
+		Name:        "hook_timeout",
+		Description: "time limit of each command declared by a tooth, 0 for none",
+		Kind:        ConfigKindDuration,
+	},
+	{
+		Name:        "lock_timeout",
+		Description: "how long to wait for a lock held by another lip process, 0 for forever",
+		Kind:        ConfigKindDuration,
+	},
+	{
+		Name: "http_cache_ttl",
+		Description: "how long cached version lists and other metadata are used without " +
+			"revalidation, 0 for always revalidating",
+		Kind: ConfigKindDuration,
+	},
+	{
+		Name:        "trusted_teeth",
+		Description: "tooth repository paths or prefixes whose commands run without confirmation",
+		Kind:        ConfigKindList,
+		Sensitive:   true,
+	},
+	{
+		Name: "auth",
+		Description: "credentials of hosts, keyed by host or host:port, " +
+			"each with token, username and password, headers or helper",
+		Kind:      ConfigKindMap,
+		Sensitive: true,
+	},
+	{
+		Name: "url_rewrites",
+		Description: "ordered rules rewriting the URL of every request, " +
+			"each with prefix or regex, replacement and optional fallback",
+		Kind:      ConfigKindList,
+		validate:  validateURLRewrites,
+		Sensitive: true,
+	},
+	{
+		Name: "roots",
+		Description: "named install roots referenced by teeth as $(root:<name>), " +
+			"keyed by name, each a directory relative to the workspace",
+		Kind:     ConfigKindMap,
+		validate: validateRoots,
+	},
+}
+
+// ConfigKeys returns all config keys in order of declaration.
+func ConfigKeys() []ConfigKey {
+	return configKeys
+}
+
+// LookupConfigKey returns the config key with the name.
+func LookupConfigKey(name string) (ConfigKey, bool) {
+	for _, key := range configKeys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+
+	return ConfigKey{}, false
+}
+
+// ConfigEnvVar returns the name of the environment variable overriding the key,
+// e.g. LIP_PROXY_URL for proxy_url.
+func ConfigEnvVar(name string) string {
+	return "LIP_" + strings.ToUpper(name)
+}
+
+// ParseValue converts a value given as a string on the command line or in an
+// environment variable to JSON. Lists are given as comma-separated values or JSON
+// arrays, and maps as JSON objects.
+func (key ConfigKey) ParseValue(valueStr string) (json.RawMessage, error) {
+	switch key.Kind {
+	case ConfigKindList:
+		if strings.HasPrefix(strings.TrimSpace(valueStr), "[") {
+			return json.RawMessage(valueStr), nil
+		}
+
+		items := make([]string, 0)
+		for _, item := range strings.Split(valueStr, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			items = append(items, item)
+		}
+
+		return json.Marshal(items)
+
+	case ConfigKindMap:
+		if !strings.HasPrefix(strings.TrimSpace(valueStr), "{") {
+			return nil, fmt.Errorf("value of %v must be a JSON object", key.Name)
+		}
+
+		return json.RawMessage(valueStr), nil
+
+	case ConfigKindBool:
+		boolValue, err := strconv.ParseBool(valueStr)
+		if err != nil {
+			return nil, fmt.Errorf("value of %v must be true or false", key.Name)
+		}
+
+		return json.Marshal(boolValue)
+
+	default:
+		return json.Marshal(valueStr)
+	}
+}
+
+// Validate checks a JSON value of the key.
+func (key ConfigKey) Validate(value json.RawMessage) error {
+	if err := key.validateKind(value); err != nil {
+		return err
+	}
+
+	if key.validate != nil {
+		if err := key.validate(value); err != nil {
+			return fmt.Errorf("invalid value for %v\n\t%w", key.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the JSON value of the key.
+func (config *Config) Get(name string) (json.RawMessage, error) {
+	values, err := config.toRawMap()
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := values[name]
+	if !ok {
+		return nil, fmt.Errorf("no such config key: %v", name)
+	}
+
+	return value, nil
+}
+
+// Set validates and sets the JSON value of the key. Lists and maps are replaced,
+// not merged.
+func (config *Config) Set(name string, value json.RawMessage) error {
+	key, ok := LookupConfigKey(name)
+	if !ok {
+		return fmt.Errorf("no such config key: %v", name)
+	}
+
+	if err := key.Validate(value); err != nil {
+		return err
+	}
+
+	if err := config.setRaw(map[string]json.RawMessage{name: value}); err != nil {
+		return fmt.Errorf("invalid value for %v\n\t%w", name, err)
+	}
+
+	return nil
+}
+
+// SetString sets the value of the key from a string given on the command line or
+// in an environment variable.
+func (config *Config) SetString(name string, valueStr string) error {
+	key, ok := LookupConfigKey(name)
+	if !ok {
+		return fmt.Errorf("no such config key: %v", name)
+	}
+
+	value, err := key.ParseValue(valueStr)
+	if err != nil {
+		return err
+	}
+
+	return config.Set(name, value)
+}
+
+// ---------------------------------------------------------------------
+
+// validateKind checks that a JSON value is of the kind of the key.
+func (key ConfigKey) validateKind(value json.RawMessage) error {
+	switch key.Kind {
+	case ConfigKindList:
+		var items []json.RawMessage
+		if err := json.Unmarshal(value, &items); err != nil {
+			return fmt.Errorf("value of %v must be a list\n\t%w", key.Name, err)
+		}
+
+		return nil
+
+	case ConfigKindMap:
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(value, &entries); err != nil {
+			return fmt.Errorf("value of %v must be a map\n\t%w", key.Name, err)
+		}
+
+		return nil
+
+	case ConfigKindBool:
+		var boolValue bool
+		if err := json.Unmarshal(value, &boolValue); err != nil {
+			return fmt.Errorf("value of %v must be true or false\n\t%w", key.Name, err)
+		}
+
+		return nil
+	}
+
+	var valueStr string
+	if err := json.Unmarshal(value, &valueStr); err != nil {
+		return fmt.Errorf("value of %v must be a string\n\t%w", key.Name, err)
+	}
+
+	switch key.Kind {
+	case ConfigKindPath:
+		if valueStr == "" {
+			return nil
+		}
+
+		if _, err := os.Stat(valueStr); err != nil {
+			return fmt.Errorf("invalid path %v for %v\n\t%w", valueStr, key.Name, err)
+		}
+
+	case ConfigKindURL:
+		if valueStr == "" && key.Optional {
+			return nil
+		}
+
+		u, err := url.Parse(valueStr)
+		if err != nil {
+			return fmt.Errorf("invalid URL %v for %v\n\t%w", valueStr, key.Name, err)
+		}
+
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid URL %v for %v: must be absolute with a host", valueStr, key.Name)
+		}
+
+	case ConfigKindEnum:
+		for _, allowed := range key.Values {
+			if valueStr == allowed {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("invalid value %v for %v: must be one of %v",
+			valueStr, key.Name, strings.Join(key.Values, ", "))
+
+	case ConfigKindDuration:
+		if valueStr == "" {
+			return nil
+		}
+
+		duration, err := time.ParseDuration(valueStr)
+		if err != nil {
+			return fmt.Errorf("invalid duration %v for %v\n\t%w", valueStr, key.Name, err)
+		}
+
+		if duration < 0 {
+			return fmt.Errorf("invalid duration %v for %v: must not be negative", valueStr, key.Name)
+		}
+	}
+
+	return nil
+}
+
+// setRaw sets the JSON values of known keys without validation. Unknown keys are
+// ignored.
+func (config *Config) setRaw(values map[string]json.RawMessage) error {
+	merged, err := config.toRawMap()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range configKeys {
+		if value, ok := values[key.Name]; ok {
+			merged[key.Name] = value
+		}
+	}
+
+	jsonBytes, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("cannot marshal config\n\t%w", err)
+	}
+
+	// Unmarshal into a zero config so that maps are replaced instead of merged.
+	var newConfig Config
+	if err := json.Unmarshal(jsonBytes, &newConfig); err != nil {
+		return fmt.Errorf("cannot unmarshal config\n\t%w", err)
+	}
+
+	*config = newConfig
+
+	return nil
+}
+
+func (config *Config) toRawMap() (map[string]json.RawMessage, error) {
+	jsonBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal config\n\t%w", err)
+	}
+
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(jsonBytes, &values); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal config\n\t%w", err)
+	}
+
+	return values, nil
+}
+
+func validateURLRewrites(value json.RawMessage) error {
+	var rules []network.RewriteRule
+	if err := json.Unmarshal(value, &rules); err != nil {
+		return fmt.Errorf("cannot unmarshal rewrite rules\n\t%w", err)
+	}
+
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("invalid rewrite rule %v\n\t%w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func validateRoots(value json.RawMessage) error {
+	var roots map[string]string
+	if err := json.Unmarshal(value, &roots); err != nil {
+		return fmt.Errorf("cannot unmarshal roots, each must be a string\n\t%w", err)
+	}
+
+	for name, dir := range roots {
+		if !rootNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid root name %v, must contain only letters, digits, - and _", name)
+		}
+
+		if _, err := parseRootDir(dir); err != nil {
+			return fmt.Errorf("invalid root %v\n\t%w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func validateProxyURL(value json.RawMessage) error {
+	var proxyURLStr string
+	if err := json.Unmarshal(value, &proxyURLStr); err != nil {
+		return fmt.Errorf("cannot unmarshal proxy URL\n\t%w", err)
+	}
+
+	if proxyURLStr == "" {
+		return nil
+	}
+
+	proxyURL, err := url.Parse(proxyURLStr)
+	if err != nil {
+		return fmt.Errorf("cannot parse proxy URL\n\t%w", err)
+	}
+
+	for _, scheme := range network.ProxySchemes {
+		if proxyURL.Scheme == scheme {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported proxy scheme %v: must be one of %v",
+		proxyURL.Scheme, strings.Join(network.ProxySchemes, ", "))
 }
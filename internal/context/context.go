@@ -1,34 +1,128 @@
 package context
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/blang/semver/v4"
+	"github.com/lippkg/lip/internal/lock"
+	"github.com/lippkg/lip/internal/network"
 	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// Script policies control whether the commands declared by a tooth are run.
+const (
+	ScriptPolicyAlways         = "always"
+	ScriptPolicyNever          = "never"
+	ScriptPolicyPrompt         = "prompt"
+	ScriptPolicyTrustedAuthors = "trusted-authors"
+)
+
+// Origins of config values other than config files.
+const (
+	ConfigOriginDefault     = "default"
+	ConfigOriginCommandLine = "command line"
 )
 
 // Context is the context of the application.
 type Context struct {
-	config     Config
-	lipVersion semver.Version
+	config        Config
+	defaultConfig Config
+	// configOverrides are the overrides given by --config.
+	configOverrides []string
+	configOrigins   map[string]string
+	userConfig      map[string]json.RawMessage
+	lipVersion      semver.Version
+	// nonInteractive is set by --non-interactive.
+	nonInteractive bool
+	// workspaceDir is set by --workspace or on first discovery.
+	workspaceDir path.Path
+	// workspaceLock is the last lock acquired by LockWorkspace.
+	workspaceLock *lock.Lock
 }
 
-// New creates a new context.
+// New creates a new context. The config given is the default config.
 func New(config Config, version semver.Version) *Context {
 	return &Context{
-		config:     config,
-		lipVersion: version,
+		config:        config,
+		defaultConfig: config,
+		configOrigins: make(map[string]string),
+		userConfig:    make(map[string]json.RawMessage),
+		lipVersion:    version,
+		workspaceDir:  path.MakeEmpty(),
 	}
 }
 
-// Config returns the config.
+// Config returns the effective config, merged from all layers.
 func (ctx *Context) Config() *Config {
 	return &ctx.config
 }
 
+// ConfigOrigin returns where the effective value of the key came from.
+func (ctx *Context) ConfigOrigin(key string) string {
+	if origin, ok := ctx.configOrigins[key]; ok {
+		return origin
+	}
+
+	return ConfigOriginDefault
+}
+
+// IsConfigOverridden reports whether the value of the key in the user config file
+// is overridden by a layer of higher precedence.
+func (ctx *Context) IsConfigOverridden(key string) bool {
+	origin := ctx.ConfigOrigin(key)
+
+	return strings.HasPrefix(origin, "workspace: ") || strings.HasPrefix(origin, "env: ") ||
+		origin == ConfigOriginCommandLine
+}
+
+// UserConfigValue returns the JSON value of the key in the user config file.
+func (ctx *Context) UserConfigValue(key string) (json.RawMessage, bool) {
+	value, ok := ctx.userConfig[key]
+	return value, ok
+}
+
+// SetUserConfigValue validates and sets the JSON value of the key in the user
+// config file and saves it. The effective config is not changed.
+func (ctx *Context) SetUserConfigValue(key string, value json.RawMessage) error {
+	// Setting the value on a scratch config checks both the key and the value.
+	if err := new(Config).Set(key, value); err != nil {
+		return err
+	}
+
+	ctx.userConfig[key] = value
+
+	return ctx.SaveConfigFile()
+}
+
+// UnsetUserConfigValue removes the key from the user config file and saves it.
+func (ctx *Context) UnsetUserConfigValue(key string) error {
+	if _, ok := LookupConfigKey(key); !ok {
+		return fmt.Errorf("no such config key: %v", key)
+	}
+
+	delete(ctx.userConfig, key)
+
+	return ctx.SaveConfigFile()
+}
+
+// ResetUserConfig removes all keys from the user config file and saves it.
+func (ctx *Context) ResetUserConfig() error {
+	ctx.userConfig = make(map[string]json.RawMessage)
+
+	return ctx.SaveConfigFile()
+}
+
 // GitHubMirrorURL returns the GitHub mirror URL.
 func (ctx *Context) GitHubMirrorURL() (*url.URL, error) {
 	gitHubMirrorURL, err := url.Parse(ctx.config.GitHubMirrorURL)
@@ -59,6 +153,198 @@ func (ctx *Context) ProxyURL() (*url.URL, error) {
 	return proxyURL, nil
 }
 
+// NetworkOptions returns the options of HTTP requests: the proxy, the credentials
+// of hosts in config and the netrc file, which is $NETRC or ~/.netrc (~/_netrc on
+// Windows if ~/.netrc does not exist), the URL rewrite rules, the TLS settings and
+// the User-Agent.
+func (ctx *Context) NetworkOptions() (network.Options, error) {
+	proxyURL, err := ctx.ProxyURL()
+	if err != nil {
+		return network.Options{}, err
+	}
+
+	netrcPath := os.Getenv("NETRC")
+	if netrcPath == "" {
+		userHomeDir, err := os.UserHomeDir()
+		if err != nil {
+			return network.Options{}, fmt.Errorf("cannot get user home directory\n\t%w", err)
+		}
+
+		netrcPath = filepath.Join(userHomeDir, ".netrc")
+
+		if runtime.GOOS == "windows" {
+			if _, err := os.Stat(netrcPath); os.IsNotExist(err) {
+				netrcPath = filepath.Join(userHomeDir, "_netrc")
+			}
+		}
+	}
+
+	// The GitHub mirror is an implicit rule after those in config.
+	rewriteRules := append([]network.RewriteRule{}, ctx.config.URLRewrites...)
+
+	gitHubMirrorURL, err := ctx.GitHubMirrorURL()
+	if err != nil {
+		return network.Options{}, err
+	}
+
+	if gitHubMirrorURL.Host != "github.com" {
+		rewriteRules = append(rewriteRules, network.GitHubMirrorRule(gitHubMirrorURL))
+	}
+
+	httpCacheTTL, err := ctx.HTTPCacheTTL()
+	if err != nil {
+		return network.Options{}, err
+	}
+
+	cacheDir, err := ctx.CacheDir()
+	if err != nil {
+		return network.Options{}, fmt.Errorf("cannot get cache directory\n\t%w", err)
+	}
+
+	return network.Options{
+		ProxyURL:     proxyURL,
+		NoProxy:      ctx.config.NoProxy,
+		Auth:         ctx.config.Auth,
+		NetrcPath:    netrcPath,
+		RewriteRules: rewriteRules,
+
+		CABundlePath:       ctx.config.CABundle,
+		ClientCertPath:     ctx.config.ClientCert,
+		ClientKeyPath:      ctx.config.ClientKey,
+		InsecureSkipVerify: ctx.config.InsecureSkipVerify,
+		UserAgent:          fmt.Sprintf("lip/%v", ctx.lipVersion.String()),
+
+		ResponseCacheDir: cacheDir.Join(path.MustParse("http")).LocalString(),
+		ResponseCacheTTL: httpCacheTTL,
+	}, nil
+}
+
+// ScriptPolicy returns the script policy.
+func (ctx *Context) ScriptPolicy() (string, error) {
+	switch ctx.config.ScriptPolicy {
+	case ScriptPolicyAlways, ScriptPolicyNever, ScriptPolicyPrompt, ScriptPolicyTrustedAuthors:
+		return ctx.config.ScriptPolicy, nil
+
+	default:
+		return "", fmt.Errorf("invalid script policy %v", ctx.config.ScriptPolicy)
+	}
+}
+
+// HookTimeout returns the timeout of each command declared by a tooth. Zero means
+// no timeout.
+func (ctx *Context) HookTimeout() (time.Duration, error) {
+	if ctx.config.HookTimeout == "" {
+		return 0, nil
+	}
+
+	hookTimeout, err := time.ParseDuration(ctx.config.HookTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse hook timeout\n\t%w", err)
+	}
+
+	if hookTimeout < 0 {
+		return 0, fmt.Errorf("hook timeout must not be negative: %v", ctx.config.HookTimeout)
+	}
+
+	return hookTimeout, nil
+}
+
+// HTTPCacheTTL returns how long cached HTTP responses are used without
+// revalidation. Zero means always revalidating.
+func (ctx *Context) HTTPCacheTTL() (time.Duration, error) {
+	if ctx.config.HTTPCacheTTL == "" {
+		return 0, nil
+	}
+
+	httpCacheTTL, err := time.ParseDuration(ctx.config.HTTPCacheTTL)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse HTTP cache TTL\n\t%w", err)
+	}
+
+	if httpCacheTTL < 0 {
+		return 0, fmt.Errorf("HTTP cache TTL must not be negative: %v", ctx.config.HTTPCacheTTL)
+	}
+
+	return httpCacheTTL, nil
+}
+
+// LockTimeout returns how long to wait for a lock held by another lip process.
+// Zero means waiting forever.
+func (ctx *Context) LockTimeout() (time.Duration, error) {
+	if ctx.config.LockTimeout == "" {
+		return 0, nil
+	}
+
+	lockTimeout, err := time.ParseDuration(ctx.config.LockTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse lock timeout\n\t%w", err)
+	}
+
+	if lockTimeout < 0 {
+		return 0, fmt.Errorf("lock timeout must not be negative: %v", ctx.config.LockTimeout)
+	}
+
+	return lockTimeout, nil
+}
+
+// LockWorkspace acquires the exclusive lock of the workspace. The lock must be
+// released after modifying the workspace.
+func (ctx *Context) LockWorkspace() (*lock.Lock, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	lockTimeout, err := ctx.LockTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	workspaceLock, err := lock.Acquire(localDotLipDir.Join(path.MustParse("lock")), lockTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock workspace\n\t%w", err)
+	}
+
+	ctx.workspaceLock = workspaceLock
+
+	return workspaceLock, nil
+}
+
+// HoldsWorkspaceLock checks if the workspace lock is held by this context, as
+// acquiring it again would wait for itself.
+func (ctx *Context) HoldsWorkspaceLock() bool {
+	return ctx.workspaceLock != nil && ctx.workspaceLock.IsHeld()
+}
+
+// TrustedTeeth returns the tooth repo paths or prefixes whose commands are
+// allowed to run without confirmation.
+func (ctx *Context) TrustedTeeth() []string {
+	return ctx.config.TrustedTeeth
+}
+
+// SetNonInteractive forbids asking for input when nonInteractive is true.
+func (ctx *Context) SetNonInteractive(nonInteractive bool) {
+	ctx.nonInteractive = nonInteractive
+}
+
+// IsInteractive returns whether lip may ask for input. It may not with
+// --non-interactive, with LIP_NONINTERACTIVE set to a value other than false or 0,
+// or when stdin is not a terminal.
+func (ctx *Context) IsInteractive() bool {
+	if ctx.nonInteractive {
+		return false
+	}
+
+	if value := os.Getenv("LIP_NONINTERACTIVE"); value != "" {
+		if nonInteractive, err := strconv.ParseBool(value); err != nil || nonInteractive {
+			return false
+		}
+	}
+
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
 // LipVersion returns the lip version.
 func (ctx *Context) LipVersion() semver.Version {
 	return ctx.lipVersion
@@ -85,17 +371,171 @@ func (ctx *Context) GlobalDotLipDir() (path.Path, error) {
 // LocalDotLipDir returns the local .lip directory.
 func (ctx *Context) LocalDotLipDir() (path.Path, error) {
 
-	workspaceDirStr, err := os.Getwd()
+	workspaceDir, err := ctx.WorkspaceDir()
 	if err != nil {
 		return path.Path{}, fmt.Errorf("cannot get workspace directory\n\t%w", err)
 	}
 
+	path := workspaceDir.Join(path.MustParse(".lip"))
+
+	return path, nil
+}
+
+// GlobalWorkspaceDir returns the user-level workspace for teeth installed with
+// --global.
+func (ctx *Context) GlobalWorkspaceDir() (path.Path, error) {
+
+	globalDotLipDir, err := ctx.GlobalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get global .lip directory\n\t%w", err)
+	}
+
+	path := globalDotLipDir.Join(path.MustParse("global"))
+
+	return path, nil
+}
+
+// UseGlobalWorkspace switches to the global workspace. The config is resolved
+// again, so that the config file of the global workspace applies instead of that
+// of the current one. The bin directory of the global workspace is the bin root
+// unless configured otherwise.
+func (ctx *Context) UseGlobalWorkspace() error {
+	globalWorkspaceDir, err := ctx.GlobalWorkspaceDir()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(globalWorkspaceDir.Join(path.MustParse("bin")).LocalString(), 0755); err != nil {
+		return fmt.Errorf("cannot create global workspace\n\t%w", err)
+	}
+
+	ctx.workspaceDir = globalWorkspaceDir
+
+	if err := ctx.CreateDirStructure(); err != nil {
+		return fmt.Errorf("cannot create directory structure\n\t%w", err)
+	}
+
+	if err := ctx.reloadConfig(); err != nil {
+		return err
+	}
+
+	if _, ok := ctx.config.Roots["bin"]; !ok {
+		roots := map[string]string{"bin": "bin"}
+		for name, dir := range ctx.config.Roots {
+			roots[name] = dir
+		}
+
+		ctx.config.Roots = roots
+	}
+
+	return nil
+}
+
+// SetWorkspaceDir sets the workspace directory instead of discovering it. A
+// relative dir is resolved against the current directory.
+func (ctx *Context) SetWorkspaceDir(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("cannot get absolute path of %v\n\t%w", dir, err)
+	}
+
+	fileInfo, err := os.Stat(absDir)
+	if err != nil {
+		return fmt.Errorf("cannot access workspace directory %v\n\t%w", dir, err)
+	} else if !fileInfo.IsDir() {
+		return fmt.Errorf("workspace %v is not a directory", dir)
+	}
+
+	workspaceDir, err := path.Parse(absDir)
+	if err != nil {
+		return fmt.Errorf("cannot parse workspace directory\n\t%w", err)
+	}
+
+	ctx.workspaceDir = workspaceDir
+
+	return nil
+}
+
+// WorkspaceDir returns the workspace directory. Unless set by SetWorkspaceDir, it
+// is the nearest directory containing a .lip directory, walking up from the
+// current directory like git does, or the current directory if there is none.
+// The home directory is passed over, as its .lip directory is the global one.
+func (ctx *Context) WorkspaceDir() (path.Path, error) {
+	if !ctx.workspaceDir.IsEmpty() {
+		return ctx.workspaceDir, nil
+	}
+
+	currentDirStr, err := os.Getwd()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get current directory\n\t%w", err)
+	}
+
+	userHomeDirStr, _ := os.UserHomeDir()
+
+	workspaceDirStr := currentDirStr
+	for dirStr := currentDirStr; ; {
+		if dirStr == currentDirStr || dirStr != userHomeDirStr {
+			if fileInfo, err := os.Stat(filepath.Join(dirStr, ".lip")); err == nil && fileInfo.IsDir() {
+				workspaceDirStr = dirStr
+				break
+			}
+		}
+
+		parentDirStr := filepath.Dir(dirStr)
+		if parentDirStr == dirStr {
+			break
+		}
+
+		dirStr = parentDirStr
+	}
+
 	workspaceDir, err := path.Parse(workspaceDirStr)
 	if err != nil {
 		return path.Path{}, fmt.Errorf("cannot parse workspace directory\n\t%w", err)
 	}
 
-	path := workspaceDir.Join(path.MustParse(".lip"))
+	ctx.workspaceDir = workspaceDir
+
+	return workspaceDir, nil
+}
+
+// BackupDir returns the directory of existing files moved aside on install.
+func (ctx *Context) BackupDir() (path.Path, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("backup"))
+
+	return path, nil
+}
+
+// BinDir returns the directory of the shims of executables declared by teeth. It
+// is the bin directory in the global workspace, and .lip/bin in other workspaces.
+func (ctx *Context) BinDir() (path.Path, error) {
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get workspace directory\n\t%w", err)
+	}
+
+	globalWorkspaceDir, err := ctx.GlobalWorkspaceDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get global workspace directory\n\t%w", err)
+	}
+
+	if workspaceDir.Equal(globalWorkspaceDir) {
+		return workspaceDir.Join(path.MustParse("bin")), nil
+	}
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("bin"))
 
 	return path, nil
 }
@@ -113,7 +553,47 @@ func (ctx *Context) CacheDir() (path.Path, error) {
 	return path, nil
 }
 
-// MetadataDir returns the metadata directory.
+// EffectsDir returns the directory of recorded effects of actions.
+func (ctx *Context) EffectsDir() (path.Path, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("effects"))
+
+	return path, nil
+}
+
+// JournalDir returns the directory of recorded transactions.
+func (ctx *Context) JournalDir() (path.Path, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("journal"))
+
+	return path, nil
+}
+
+// LogsDir returns the directory of command logs.
+func (ctx *Context) LogsDir() (path.Path, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("logs"))
+
+	return path, nil
+}
+
+// MetadataDir returns the directory of metadata files of older versions of lip,
+// which are migrated into the state file.
 func (ctx *Context) MetadataDir() (path.Path, error) {
 
 	localDotLipDir, err := ctx.LocalDotLipDir()
@@ -126,6 +606,19 @@ func (ctx *Context) MetadataDir() (path.Path, error) {
 	return path, nil
 }
 
+// StateFilePath returns the path of the state file recording installed teeth.
+func (ctx *Context) StateFilePath() (path.Path, error) {
+
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
+
+	path := localDotLipDir.Join(path.MustParse("state.json"))
+
+	return path, nil
+}
+
 // CreateDirStructure creates the directory structure.
 func (ctx *Context) CreateDirStructure() error {
 
@@ -156,59 +649,256 @@ func (ctx *Context) CreateDirStructure() error {
 		return fmt.Errorf("cannot create cache directory\n\t%w", err)
 	}
 
-	metadataDir, err := ctx.MetadataDir()
+	effectsDir, err := ctx.EffectsDir()
+	if err != nil {
+		return fmt.Errorf("cannot get effects directory\n\t%w", err)
+	}
+
+	if err := os.MkdirAll(effectsDir.LocalString(), 0755); err != nil {
+		return fmt.Errorf("cannot create effects directory\n\t%w", err)
+	}
+
+	logsDir, err := ctx.LogsDir()
 	if err != nil {
-		return fmt.Errorf("cannot get metadata directory\n\t%w", err)
+		return fmt.Errorf("cannot get logs directory\n\t%w", err)
 	}
 
-	if err := os.MkdirAll(metadataDir.LocalString(), 0755); err != nil {
-		return fmt.Errorf("cannot create metadata directory\n\t%w", err)
+	if err := os.MkdirAll(logsDir.LocalString(), 0755); err != nil {
+		return fmt.Errorf("cannot create logs directory\n\t%w", err)
 	}
 
 	return nil
 }
 
-// LoadOrCreateConfigFile loads or creates the config file.
-func (ctx *Context) LoadOrCreateConfigFile() error {
+// SystemConfigFilePath returns the path of the system-wide config file.
+func (ctx *Context) SystemConfigFilePath() (path.Path, error) {
+	if runtime.GOOS == "windows" {
+		programDataDirStr := os.Getenv("ProgramData")
+		if programDataDirStr == "" {
+			programDataDirStr = `C:\ProgramData`
+		}
+
+		programDataDir, err := path.Parse(programDataDirStr)
+		if err != nil {
+			return path.Path{}, fmt.Errorf("cannot parse ProgramData directory\n\t%w", err)
+		}
+
+		return programDataDir.Join(path.MustParse("lip/config.json")), nil
+	}
+
+	return path.MustParse("/etc/lip/config.json"), nil
+}
+
+// UserConfigFilePath returns the path of the user config file.
+func (ctx *Context) UserConfigFilePath() (path.Path, error) {
 
 	globalDotLipDir, err := ctx.GlobalDotLipDir()
 	if err != nil {
-		return fmt.Errorf("cannot get global .lip directory\n\t%w", err)
+		return path.Path{}, fmt.Errorf("cannot get global .lip directory\n\t%w", err)
 	}
 
-	configFilePath := globalDotLipDir.Join(path.MustParse("config.json"))
+	return globalDotLipDir.Join(path.MustParse("config.json")), nil
+}
 
-	if _, err := os.Stat(configFilePath.LocalString()); os.IsNotExist(err) {
-		ctx.SaveConfigFile()
+// WorkspaceConfigFilePath returns the path of the workspace config file.
+func (ctx *Context) WorkspaceConfigFilePath() (path.Path, error) {
 
-	} else if err != nil {
-		return fmt.Errorf("cannot get config file info\n\t%w", err)
+	localDotLipDir, err := ctx.LocalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get local .lip directory\n\t%w", err)
+	}
 
-	} else {
-		jsonBytes, err := os.ReadFile(configFilePath.LocalString())
-		if err != nil {
-			return fmt.Errorf("cannot read config file at %v\n\t%w", configFilePath.LocalString(), err)
+	return localDotLipDir.Join(path.MustParse("config.json")), nil
+}
+
+// LoadOrCreateConfigFile resolves the config from the system config file, the
+// user config file, the workspace config file and LIP_* environment variables,
+// in order of increasing precedence. The user config file is created if it does
+// not exist.
+func (ctx *Context) LoadOrCreateConfigFile() error {
+
+	systemConfigFilePath, err := ctx.SystemConfigFilePath()
+	if err != nil {
+		return fmt.Errorf("cannot get system config file path\n\t%w", err)
+	}
+
+	userConfigFilePath, err := ctx.UserConfigFilePath()
+	if err != nil {
+		return fmt.Errorf("cannot get user config file path\n\t%w", err)
+	}
+
+	workspaceConfigFilePath, err := ctx.WorkspaceConfigFilePath()
+	if err != nil {
+		return fmt.Errorf("cannot get workspace config file path\n\t%w", err)
+	}
+
+	if _, err := ctx.loadConfigLayer("system", systemConfigFilePath, true); err != nil {
+		return err
+	}
+
+	// The user config file keeps only the keys set explicitly, so that it does not
+	// shadow the system config file with default values.
+	if err := ctx.migrateLegacyUserConfigFile(userConfigFilePath); err != nil {
+		return err
+	}
+
+	userConfig, err := ctx.loadConfigLayer("user", userConfigFilePath, true)
+	if err != nil {
+		return err
+	}
+
+	if userConfig != nil {
+		ctx.userConfig = userConfig
+
+	} else if err := ctx.SaveConfigFile(); err != nil {
+		return err
+	}
+
+	if _, err := ctx.loadConfigLayer("workspace", workspaceConfigFilePath, false); err != nil {
+		return err
+	}
+
+	for _, key := range ConfigKeys() {
+		envVar := ConfigEnvVar(key.Name)
+
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			continue
 		}
 
-		if err := json.Unmarshal(jsonBytes, &ctx.config); err != nil {
-			return fmt.Errorf("cannot unmarshal config at %v\n\t%w", configFilePath.LocalString(), err)
+		if err := ctx.config.SetString(key.Name, value); err != nil {
+			return fmt.Errorf("cannot apply environment variable %v\n\t%w", envVar, err)
 		}
+
+		ctx.configOrigins[key.Name] = "env: " + envVar
 	}
 
 	return nil
 }
 
-// SaveConfigFile saves the config file.
+// reloadConfig resolves the config again from the default config, as the
+// workspace config file may have changed with the workspace.
+func (ctx *Context) reloadConfig() error {
+	// Copy the default config deeply, as config layers are merged into its maps.
+	jsonBytes, err := json.Marshal(ctx.defaultConfig)
+	if err != nil {
+		return fmt.Errorf("cannot marshal default config\n\t%w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(jsonBytes, &config); err != nil {
+		return fmt.Errorf("cannot unmarshal default config\n\t%w", err)
+	}
+
+	ctx.config = config
+	ctx.configOrigins = make(map[string]string)
+
+	if err := ctx.LoadOrCreateConfigFile(); err != nil {
+		return fmt.Errorf("cannot load or create config file\n\t%w", err)
+	}
+
+	return ctx.ApplyConfigOverrides(ctx.configOverrides)
+}
+
+// ApplyConfigOverrides applies overrides in the form of key=value given on the
+// command line. They take precedence over all other layers.
+func (ctx *Context) ApplyConfigOverrides(overrides []string) error {
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("invalid config override %v: expected key=value", override)
+		}
+
+		key = strings.TrimSpace(key)
+
+		if err := ctx.config.SetString(key, value); err != nil {
+			return fmt.Errorf("cannot apply config override %v\n\t%w", override, err)
+		}
+
+		ctx.configOrigins[key] = ConfigOriginCommandLine
+	}
+
+	ctx.configOverrides = overrides
+
+	return nil
+}
+
+// SaveConfigFile saves the keys set in the user config file.
 func (ctx *Context) SaveConfigFile() error {
 
-	globalDotLipDir, err := ctx.GlobalDotLipDir()
+	configFilePath, err := ctx.UserConfigFilePath()
 	if err != nil {
-		return fmt.Errorf("cannot get global .lip directory\n\t%w", err)
+		return fmt.Errorf("cannot get user config file path\n\t%w", err)
 	}
 
-	configFilePath := globalDotLipDir.Join(path.MustParse("config.json"))
+	jsonBytes, err := json.MarshalIndent(ctx.userConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal config\n\t%w", err)
+	}
 
-	jsonBytes, err := json.MarshalIndent(ctx.config, "", "  ")
+	if err := os.WriteFile(configFilePath.LocalString(), jsonBytes, 0644); err != nil {
+		return fmt.Errorf("cannot write config file\n\t%w", err)
+	}
+
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// legacyConfigKeys are the keys that older versions of lip wrote to the user config
+// file all at once, whether set or not.
+var legacyConfigKeys = []string{"github_mirror_url", "go_module_proxy_url", "proxy_url"}
+
+// migrateLegacyUserConfigFile removes the keys at their default values from a user
+// config file written by an older version of lip, which contains every key. It does
+// nothing to other config files.
+func (ctx *Context) migrateLegacyUserConfigFile(configFilePath path.Path) error {
+	jsonBytes, err := os.ReadFile(configFilePath.LocalString())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot read user config file at %v\n\t%w", configFilePath.LocalString(), err)
+	}
+
+	rawConfig := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(jsonBytes, &rawConfig); err != nil {
+		return fmt.Errorf("cannot unmarshal user config at %v\n\t%w", configFilePath.LocalString(), err)
+	}
+
+	for _, key := range legacyConfigKeys {
+		if _, ok := rawConfig[key]; !ok {
+			return nil
+		}
+	}
+
+	defaultRawConfig, err := ctx.defaultConfig.toRawMap()
+	if err != nil {
+		return err
+	}
+
+	isMigrated := false
+	for key, value := range rawConfig {
+		defaultValue, ok := defaultRawConfig[key]
+		if !ok {
+			continue
+		}
+
+		var compactValue bytes.Buffer
+		if err := json.Compact(&compactValue, value); err != nil {
+			return fmt.Errorf("cannot compact value of %v\n\t%w", key, err)
+		}
+
+		if bytes.Equal(compactValue.Bytes(), defaultValue) {
+			delete(rawConfig, key)
+			isMigrated = true
+		}
+	}
+
+	if !isMigrated {
+		return nil
+	}
+
+	jsonBytes, err = json.MarshalIndent(rawConfig, "", "  ")
 	if err != nil {
 		return fmt.Errorf("cannot marshal config\n\t%w", err)
 	}
@@ -219,3 +909,44 @@ func (ctx *Context) SaveConfigFile() error {
 
 	return nil
 }
+
+// loadConfigLayer merges the config file at configFilePath into the effective config
+// and records the origins of the keys it sets. Sensitive keys are ignored with a
+// warning unless isTrusted is true. It returns the raw values in the file, or nil
+// if the file does not exist.
+func (ctx *Context) loadConfigLayer(layer string, configFilePath path.Path,
+	isTrusted bool) (map[string]json.RawMessage, error) {
+	jsonBytes, err := os.ReadFile(configFilePath.LocalString())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read %v config file at %v\n\t%w", layer, configFilePath.LocalString(), err)
+	}
+
+	rawConfig := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(jsonBytes, &rawConfig); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal %v config at %v\n\t%w", layer, configFilePath.LocalString(), err)
+	}
+
+	if !isTrusted {
+		for _, key := range ConfigKeys() {
+			if _, ok := rawConfig[key.Name]; ok && key.Sensitive {
+				log.Warnf("Ignored %v in %v config at %v. Set it in the user config file instead",
+					key.Name, layer, configFilePath.LocalString())
+				delete(rawConfig, key.Name)
+			}
+		}
+	}
+
+	if err := ctx.config.setRaw(rawConfig); err != nil {
+		return nil, fmt.Errorf("cannot apply %v config at %v\n\t%w", layer, configFilePath.LocalString(), err)
+	}
+
+	for _, key := range ConfigKeys() {
+		if _, ok := rawConfig[key.Name]; ok {
+			ctx.configOrigins[key.Name] = fmt.Sprintf("%v: %v", layer, configFilePath.LocalString())
+		}
+	}
+
+	return rawConfig, nil
+}
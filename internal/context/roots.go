@@ -0,0 +1,72 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/lippkg/lip/internal/path"
+)
+
+var rootNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Roots returns the named install roots as paths relative to the workspace. Each
+// root is checked to stay inside the workspace, following symbolic links of
+// directories that exist.
+func (ctx *Context) Roots() (map[string]path.Path, error) {
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get workspace directory\n\t%w", err)
+	}
+
+	realWorkspaceDirStr, err := filepath.EvalSymlinks(workspaceDir.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve workspace directory\n\t%w", err)
+	}
+
+	roots := make(map[string]path.Path)
+	for name, dir := range ctx.config.Roots {
+		rootDir, err := parseRootDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root %v\n\t%w", name, err)
+		}
+
+		realRootDirStr, err := filepath.EvalSymlinks(workspaceDir.Join(rootDir).LocalString())
+		if os.IsNotExist(err) {
+			// The root will be created on install.
+			roots[name] = rootDir
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot resolve root %v\n\t%w", name, err)
+		}
+
+		relRootDirStr, err := filepath.Rel(realWorkspaceDirStr, realRootDirStr)
+		if err != nil || relRootDirStr == ".." || strings.HasPrefix(relRootDirStr, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("root %v at %v is outside the workspace", name, dir)
+		}
+
+		roots[name] = rootDir
+	}
+
+	return roots, nil
+}
+
+// ---------------------------------------------------------------------
+
+// parseRootDir parses the directory of a root, which must be relative to the
+// workspace and must not leave it.
+func parseRootDir(dir string) (path.Path, error) {
+	if dir == "" || filepath.IsAbs(dir) || filepath.VolumeName(dir) != "" ||
+		strings.HasPrefix(dir, "/") || strings.HasPrefix(dir, `\`) {
+		return path.Path{}, fmt.Errorf("%v must be a relative path inside the workspace", dir)
+	}
+
+	rootDir, err := path.Parse(dir)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("%v must be a relative path inside the workspace\n\t%w", dir, err)
+	}
+
+	return rootDir, nil
+}
@@ -0,0 +1,136 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/lippkg/lip/internal/lock"
+	"github.com/lippkg/lip/internal/path"
+)
+
+type rawWorkspaces struct {
+	Workspaces []string `json:"workspaces"`
+}
+
+// KnownWorkspaces returns the workspaces where teeth have been installed. Workspaces
+// that no longer exist are omitted.
+func (ctx *Context) KnownWorkspaces() ([]path.Path, error) {
+	workspaceStrs, err := ctx.loadKnownWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces := make([]path.Path, 0, len(workspaceStrs))
+	for _, workspaceStr := range workspaceStrs {
+		if _, err := os.Stat(workspaceStr); os.IsNotExist(err) {
+			continue
+		}
+
+		workspace, err := path.Parse(workspaceStr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse workspace %v\n\t%w", workspaceStr, err)
+		}
+
+		workspaces = append(workspaces, workspace)
+	}
+
+	return workspaces, nil
+}
+
+// RegisterWorkspace records the current workspace as a known workspace. The
+// known workspaces file is locked, as lip processes in other workspaces may
+// register at the same time.
+func (ctx *Context) RegisterWorkspace() error {
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("cannot get workspace directory\n\t%w", err)
+	}
+
+	workspaceDirStr := workspaceDir.LocalString()
+
+	globalDotLipDir, err := ctx.GlobalDotLipDir()
+	if err != nil {
+		return fmt.Errorf("cannot get global .lip directory\n\t%w", err)
+	}
+
+	lockTimeout, err := ctx.LockTimeout()
+	if err != nil {
+		return err
+	}
+
+	workspacesLock, err := lock.Acquire(globalDotLipDir.Join(path.MustParse("workspaces.lock")), lockTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot lock known workspaces file\n\t%w", err)
+	}
+	defer workspacesLock.Release()
+
+	workspaceStrs, err := ctx.loadKnownWorkspaces()
+	if err != nil {
+		return err
+	}
+
+	for _, workspaceStr := range workspaceStrs {
+		if workspaceStr == workspaceDirStr {
+			return nil
+		}
+	}
+
+	workspaceStrs = append(workspaceStrs, workspaceDirStr)
+	sort.Strings(workspaceStrs)
+
+	workspacesFilePath, err := ctx.workspacesFilePath()
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := json.MarshalIndent(rawWorkspaces{Workspaces: workspaceStrs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal known workspaces\n\t%w", err)
+	}
+
+	if err := os.WriteFile(workspacesFilePath.LocalString(), jsonBytes, 0644); err != nil {
+		return fmt.Errorf("cannot write known workspaces file\n\t%w", err)
+	}
+
+	return nil
+}
+
+func (ctx *Context) loadKnownWorkspaces() ([]string, error) {
+
+	workspacesFilePath, err := ctx.workspacesFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := os.ReadFile(workspacesFilePath.LocalString())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read known workspaces file\n\t%w", err)
+	}
+
+	var raw rawWorkspaces
+	if err := json.Unmarshal(jsonBytes, &raw); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal known workspaces file at %v\n\t%w",
+			workspacesFilePath.LocalString(), err)
+	}
+
+	if raw.Workspaces == nil {
+		return []string{}, nil
+	}
+
+	return raw.Workspaces, nil
+}
+
+func (ctx *Context) workspacesFilePath() (path.Path, error) {
+
+	globalDotLipDir, err := ctx.GlobalDotLipDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("cannot get global .lip directory\n\t%w", err)
+	}
+
+	return globalDotLipDir.Join(path.MustParse("workspaces.json")), nil
+}
@@ -0,0 +1,946 @@
+package install
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lippkg/lip/internal/archive"
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/tooth"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Effect kinds.
+const (
+	effectCreate        = "create"
+	effectMove          = "move"
+	effectChmod         = "chmod"
+	effectEditJSON      = "edit-json"
+	effectReplaceInFile = "replace-in-file"
+)
+
+// effect is a change made by an action. Effects are recorded on install so that
+// they can be reverted on uninstall. All paths are relative to the workspace.
+type effect struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+
+	// Src is the original location of a moved file.
+	Src string `json:"src,omitempty"`
+	// Mode is the original mode of a file whose mode is changed.
+	Mode uint32 `json:"mode,omitempty"`
+	// Key and OldValue describe an edited JSON key. If OldValue is empty, the key
+	// did not exist.
+	Key      string          `json:"key,omitempty"`
+	OldValue json.RawMessage `json:"old_value,omitempty"`
+	// Search and Replace describe a replacement in a file. Offsets are the offsets
+	// of the replacements in the new content, and Digest is the digest of the new
+	// content in the form of sha256:<hex>.
+	Search  string `json:"search,omitempty"`
+	Replace string `json:"replace,omitempty"`
+	Offsets []int  `json:"offsets,omitempty"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// runAction runs an action in the workspace and returns its effects.
+func runAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	switch action.Action {
+	case tooth.ActionCopy:
+		return runCopyAction(workspaceDir, action)
+
+	case tooth.ActionMove:
+		return runMoveAction(workspaceDir, action)
+
+	case tooth.ActionMkdir:
+		return runMkdirAction(workspaceDir, action)
+
+	case tooth.ActionDelete:
+		return runDeleteAction(workspaceDir, action)
+
+	case tooth.ActionChmod:
+		return runChmodAction(workspaceDir, action)
+
+	case tooth.ActionSymlink:
+		return runSymlinkAction(workspaceDir, action)
+
+	case tooth.ActionExtractArchive:
+		return runExtractArchiveAction(workspaceDir, action)
+
+	case tooth.ActionEditJSON:
+		return runEditJSONAction(workspaceDir, action)
+
+	case tooth.ActionReplaceInFile:
+		return runReplaceInFileAction(workspaceDir, action)
+
+	default:
+		return nil, fmt.Errorf("unknown action type %v", action.Action)
+	}
+}
+
+// isDestructiveAction checks if an action changes or deletes existing files, which
+// cannot always be reverted. Such actions are subject to the script policy. Actions
+// creating files refuse to overwrite existing ones instead.
+func isDestructiveAction(action tooth.Action) bool {
+	switch action.Action {
+	case tooth.ActionDelete, tooth.ActionMove, tooth.ActionChmod, tooth.ActionReplaceInFile, tooth.ActionEditJSON:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// describeAction returns a human-readable description of an action.
+func describeAction(action tooth.Action) string {
+	switch action.Action {
+	case tooth.ActionCopy, tooth.ActionMove, tooth.ActionSymlink, tooth.ActionExtractArchive:
+		return fmt.Sprintf("%v %v -> %v", action.Action, action.Src, action.Dest)
+
+	case tooth.ActionChmod:
+		return fmt.Sprintf("%v %v %v", action.Action, action.Mode, action.Path)
+
+	case tooth.ActionEditJSON:
+		return fmt.Sprintf("%v %v %v", action.Action, action.Path, action.Key)
+
+	default:
+		return fmt.Sprintf("%v %v", action.Action, action.Path)
+	}
+}
+
+func runCopyAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	src, err := resolveWorkspacePath(workspaceDir, action.Src)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := resolveWorkspacePath(workspaceDir, action.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(dest.LocalString()); err == nil {
+		return nil, fmt.Errorf("destination %v already exists", action.Dest)
+	}
+
+	createdPath, err := prepareCreation(workspaceDir, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := copyPath(src.LocalString(), dest.LocalString()); err != nil {
+		return nil, fmt.Errorf("failed to copy %v to %v\n\t%w", action.Src, action.Dest, err)
+	}
+
+	return makeCreateEffects(workspaceDir, createdPath), nil
+}
+
+func runMoveAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	src, err := resolveWorkspacePath(workspaceDir, action.Src)
+	if err != nil {
+		return nil, err
+	}
+
+	dest, err := resolveWorkspacePath(workspaceDir, action.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(dest.LocalString()); err == nil {
+		return nil, fmt.Errorf("destination %v already exists", action.Dest)
+	}
+
+	if _, err := prepareCreation(workspaceDir, dest); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(src.LocalString(), dest.LocalString()); err != nil {
+		return nil, fmt.Errorf("failed to move %v to %v\n\t%w", action.Src, action.Dest, err)
+	}
+
+	return []effect{{
+		Kind: effectMove,
+		Path: dest.TrimPrefix(workspaceDir).String(),
+		Src:  src.TrimPrefix(workspaceDir).String(),
+	}}, nil
+}
+
+func runMkdirAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	dir, err := resolveWorkspacePath(workspaceDir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	createdPath, err := prepareCreation(workspaceDir, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir.LocalString(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %v\n\t%w", action.Path, err)
+	}
+
+	return makeCreateEffects(workspaceDir, createdPath), nil
+}
+
+func runDeleteAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	target, err := resolveWorkspacePath(workspaceDir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Equal(workspaceDir) {
+		return nil, fmt.Errorf("refusing to delete the workspace directory")
+	}
+
+	if err := os.RemoveAll(target.LocalString()); err != nil {
+		return nil, fmt.Errorf("failed to delete %v\n\t%w", action.Path, err)
+	}
+
+	// Deletion cannot be reverted.
+	return nil, nil
+}
+
+func runChmodAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	target, err := resolveWorkspacePath(workspaceDir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := strconv.ParseUint(action.Mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mode %v\n\t%w", action.Mode, err)
+	}
+
+	fileInfo, err := os.Stat(target.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %v\n\t%w", action.Path, err)
+	}
+
+	if err := os.Chmod(target.LocalString(), os.FileMode(mode)); err != nil {
+		return nil, fmt.Errorf("failed to change mode of %v\n\t%w", action.Path, err)
+	}
+
+	return []effect{{
+		Kind: effectChmod,
+		Path: target.TrimPrefix(workspaceDir).String(),
+		Mode: uint32(fileInfo.Mode().Perm()),
+	}}, nil
+}
+
+func runSymlinkAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	target, err := resolveWorkspacePath(workspaceDir, action.Src)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := resolveWorkspacePath(workspaceDir, action.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Lstat(link.LocalString()); err == nil {
+		return nil, fmt.Errorf("destination %v already exists", action.Dest)
+	}
+
+	createdPath, err := prepareCreation(workspaceDir, link)
+	if err != nil {
+		return nil, err
+	}
+
+	linkDir, err := link.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a relative target so that the workspace can be moved.
+	relTarget, err := filepath.Rel(linkDir.LocalString(), target.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get relative path of %v\n\t%w", action.Src, err)
+	}
+
+	if err := os.Symlink(relTarget, link.LocalString()); err != nil {
+		return nil, fmt.Errorf("failed to create symlink %v\n\t%w", action.Dest, err)
+	}
+
+	return makeCreateEffects(workspaceDir, createdPath), nil
+}
+
+func runExtractArchiveAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	archivePath, err := resolveWorkspacePath(workspaceDir, action.Src)
+	if err != nil {
+		return nil, err
+	}
+
+	destDir, err := resolveWorkspacePath(workspaceDir, action.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	createdPaths := make([]path.Path, 0)
+	createFile := func(relFilePath path.Path) (*os.File, error) {
+		filePath := destDir.Join(relFilePath)
+
+		createdPath, err := prepareCreation(workspaceDir, filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if !createdPath.IsEmpty() {
+			createdPaths = append(createdPaths, createdPath)
+		}
+
+		// Existing files are never overwritten.
+		return os.OpenFile(filePath.LocalString(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+
+	format, err := archive.DetectFormat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect format of %v\n\t%w", action.Src, err)
+	} else if format == archive.RawFormat {
+		return nil, fmt.Errorf("%v is not an archive", action.Src)
+	}
+
+	r, err := archive.Open(archivePath, filepath.Base(archivePath.LocalString()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %v\n\t%w", action.Src, err)
+	}
+	defer r.Close()
+
+	// Check all files before extracting any, so that nothing is half extracted.
+	for _, filePath := range r.FilePaths() {
+		if _, err := os.Lstat(destDir.Join(filePath).LocalString()); err == nil {
+			return nil, fmt.Errorf("destination %v already exists", destDir.Join(filePath).TrimPrefix(workspaceDir).String())
+		}
+	}
+
+	err = r.Walk(func(filePath path.Path, r io.Reader) error {
+		return writeFile(filePath, r, createFile)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract %v\n\t%w", action.Src, err)
+	}
+
+	return makeCreateEffects(workspaceDir, createdPaths...), nil
+}
+
+func runEditJSONAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	filePath, err := resolveWorkspacePath(workspaceDir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var newValue interface{}
+	if err := json.Unmarshal(action.Value, &newValue); err != nil {
+		return nil, fmt.Errorf("failed to parse value\n\t%w", err)
+	}
+
+	createdPath, err := prepareCreation(workspaceDir, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := readJSONObjectFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	oldValue, exists := getJSONKey(document, action.Key)
+
+	if action.Operation == "merge" {
+		newObject, ok := newValue.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value to merge must be an object")
+		}
+
+		oldObject, ok := oldValue.(map[string]interface{})
+		if exists && !ok {
+			return nil, fmt.Errorf("key %v is not an object", action.Key)
+		}
+
+		newValue = mergeJSONObjects(oldObject, newObject)
+	}
+
+	if err := setJSONKey(document, action.Key, newValue); err != nil {
+		return nil, err
+	}
+
+	if err := writeJSONObjectFile(filePath, document); err != nil {
+		return nil, err
+	}
+
+	if !createdPath.IsEmpty() {
+		return makeCreateEffects(workspaceDir, createdPath), nil
+	}
+
+	editEffect := effect{
+		Kind: effectEditJSON,
+		Path: filePath.TrimPrefix(workspaceDir).String(),
+		Key:  action.Key,
+	}
+
+	if exists {
+		oldValueJSON, err := json.Marshal(oldValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal old value\n\t%w", err)
+		}
+		editEffect.OldValue = oldValueJSON
+	}
+
+	return []effect{editEffect}, nil
+}
+
+func runReplaceInFileAction(workspaceDir path.Path, action tooth.Action) ([]effect, error) {
+	filePath, err := resolveWorkspacePath(workspaceDir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(filePath.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	// Offsets are recorded so that exactly the replacements are reverted, and not
+	// occurrences of the replacement that were in the file before.
+	newContent := make([]byte, 0, len(content))
+	offsets := make([]int, 0)
+	for {
+		index := bytes.Index(content, []byte(action.Search))
+		if index < 0 {
+			break
+		}
+
+		newContent = append(newContent, content[:index]...)
+		offsets = append(offsets, len(newContent))
+		newContent = append(newContent, action.Replace...)
+		content = content[index+len(action.Search):]
+	}
+	newContent = append(newContent, content...)
+
+	if err := writeFileKeepingMode(filePath, newContent); err != nil {
+		return nil, err
+	}
+
+	return []effect{{
+		Kind:    effectReplaceInFile,
+		Path:    filePath.TrimPrefix(workspaceDir).String(),
+		Search:  action.Search,
+		Replace: action.Replace,
+		Offsets: offsets,
+		Digest:  getContentDigest(newContent),
+	}}, nil
+}
+
+// saveEffects saves the effects of the actions of a tooth.
+func saveEffects(ctx *context.Context, toothRepoPath string, effects []effect) error {
+	effectsFilePath, err := getEffectsFilePath(ctx, toothRepoPath)
+	if err != nil {
+		return err
+	}
+
+	if len(effects) == 0 {
+		return removeEffects(ctx, toothRepoPath)
+	}
+
+	jsonBytes, err := json.MarshalIndent(effects, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal effects\n\t%w", err)
+	}
+
+	if err := os.WriteFile(effectsFilePath.LocalString(), jsonBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write effects file\n\t%w", err)
+	}
+
+	return nil
+}
+
+// loadEffects loads the effects of the actions of a tooth. No effects are returned
+// if none were recorded.
+func loadEffects(ctx *context.Context, toothRepoPath string) ([]effect, error) {
+	effectsFilePath, err := getEffectsFilePath(ctx, toothRepoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonBytes, err := os.ReadFile(effectsFilePath.LocalString())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read effects file\n\t%w", err)
+	}
+
+	var effects []effect
+	if err := json.Unmarshal(jsonBytes, &effects); err != nil {
+		return nil, fmt.Errorf("failed to parse effects file %v\n\t%w", effectsFilePath.LocalString(), err)
+	}
+
+	return effects, nil
+}
+
+// removeEffects removes the recorded effects of a tooth.
+func removeEffects(ctx *context.Context, toothRepoPath string) error {
+	effectsFilePath, err := getEffectsFilePath(ctx, toothRepoPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(effectsFilePath.LocalString()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove effects file\n\t%w", err)
+	}
+
+	return nil
+}
+
+func getEffectsFilePath(ctx *context.Context, toothRepoPath string) (path.Path, error) {
+	effectsDir, err := ctx.EffectsDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to get effects directory\n\t%w", err)
+	}
+
+	return effectsDir.Join(path.MustParse(url.QueryEscape(toothRepoPath) + ".json")), nil
+}
+
+// revertEffects reverts effects in reverse order. Effects that cannot be reverted
+// are skipped with a warning.
+func revertEffects(workspaceDir path.Path, effects []effect) {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "install",
+		"method":  "revertEffects",
+	})
+
+	for i := len(effects) - 1; i >= 0; i-- {
+		effect := effects[i]
+
+		if err := revertEffect(workspaceDir, effect); err != nil {
+			log.Warnf("Cannot revert %v of %v\n\t%v", effect.Kind, effect.Path, err.Error())
+			continue
+		}
+
+		debugLogger.Debugf("Reverted %v of %v", effect.Kind, effect.Path)
+	}
+}
+
+func revertEffect(workspaceDir path.Path, effect effect) error {
+	target, err := resolveWorkspacePath(workspaceDir, effect.Path)
+	if err != nil {
+		return err
+	}
+
+	switch effect.Kind {
+	case effectCreate:
+		return os.RemoveAll(target.LocalString())
+
+	case effectMove:
+		src, err := resolveWorkspacePath(workspaceDir, effect.Src)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(src.LocalString()); err == nil {
+			return fmt.Errorf("original location %v is occupied", effect.Src)
+		}
+
+		if _, err := prepareCreation(workspaceDir, src); err != nil {
+			return err
+		}
+
+		return os.Rename(target.LocalString(), src.LocalString())
+
+	case effectChmod:
+		return os.Chmod(target.LocalString(), os.FileMode(effect.Mode))
+
+	case effectEditJSON:
+		document, err := readJSONObjectFile(target)
+		if err != nil {
+			return err
+		}
+
+		if len(effect.OldValue) == 0 {
+			deleteJSONKey(document, effect.Key)
+		} else {
+			var oldValue interface{}
+			if err := json.Unmarshal(effect.OldValue, &oldValue); err != nil {
+				return fmt.Errorf("failed to parse old value\n\t%w", err)
+			}
+
+			if err := setJSONKey(document, effect.Key, oldValue); err != nil {
+				return err
+			}
+		}
+
+		return writeJSONObjectFile(target, document)
+
+	case effectReplaceInFile:
+		content, err := os.ReadFile(target.LocalString())
+		if err != nil {
+			return fmt.Errorf("failed to read %v\n\t%w", target.LocalString(), err)
+		}
+
+		// The offsets are only valid for the content the action wrote.
+		if effect.Digest == "" || getContentDigest(content) != effect.Digest {
+			return fmt.Errorf("file was changed after install and is left unchanged")
+		}
+
+		oldContent := make([]byte, 0, len(content))
+		start := 0
+		for _, offset := range effect.Offsets {
+			if offset < start || offset+len(effect.Replace) > len(content) {
+				return fmt.Errorf("invalid offset %v", offset)
+			}
+
+			oldContent = append(oldContent, content[start:offset]...)
+			oldContent = append(oldContent, effect.Search...)
+			start = offset + len(effect.Replace)
+		}
+		oldContent = append(oldContent, content[start:]...)
+
+		return writeFileKeepingMode(target, oldContent)
+
+	default:
+		return fmt.Errorf("unknown effect kind %v", effect.Kind)
+	}
+}
+
+// resolveWorkspacePath resolves a relative path against the workspace directory.
+// Paths escaping the workspace are rejected, and so are paths in the .lip
+// directory, also through symbolic links, so that teeth cannot change the config
+// or the records of lip.
+func resolveWorkspacePath(workspaceDir path.Path, relPathStr string) (path.Path, error) {
+	if filepath.IsAbs(relPathStr) || strings.HasPrefix(filepath.ToSlash(relPathStr), "/") ||
+		filepath.VolumeName(relPathStr) != "" {
+		return path.Path{}, fmt.Errorf("path %v must be relative to the workspace", relPathStr)
+	}
+
+	relPath, err := path.Parse(relPathStr)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("invalid path %v\n\t%w", relPathStr, err)
+	}
+
+	// File systems may be case-insensitive.
+	if strings.EqualFold(strings.SplitN(relPath.String(), "/", 2)[0], ".lip") {
+		return path.Path{}, fmt.Errorf("path %v must not be in the .lip directory", relPathStr)
+	}
+
+	p := workspaceDir.Join(relPath)
+
+	if isInDotLipDir, err := isInDotLipDir(workspaceDir, p); err != nil {
+		return path.Path{}, err
+	} else if isInDotLipDir {
+		return path.Path{}, fmt.Errorf("path %v must not be in the .lip directory", relPathStr)
+	}
+
+	return p, nil
+}
+
+// isInDotLipDir checks if p, after following symbolic links of its existing part,
+// is the .lip directory of the workspace or inside it.
+func isInDotLipDir(workspaceDir path.Path, p path.Path) (bool, error) {
+	realWorkspaceDirStr, err := filepath.EvalSymlinks(workspaceDir.LocalString())
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve workspace directory\n\t%w", err)
+	}
+
+	// Resolve the longest existing part of p.
+	existingPathStr := p.LocalString()
+	rest := ""
+	for {
+		if _, err := os.Lstat(existingPathStr); err == nil {
+			break
+		}
+
+		parentStr := filepath.Dir(existingPathStr)
+		if parentStr == existingPathStr {
+			return false, nil
+		}
+
+		rest = filepath.Join(filepath.Base(existingPathStr), rest)
+		existingPathStr = parentStr
+	}
+
+	// Dangling symbolic links are rejected, as they may point into .lip later.
+	realExistingPathStr, err := filepath.EvalSymlinks(existingPathStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve %v\n\t%w", existingPathStr, err)
+	}
+
+	relPathStr, err := filepath.Rel(filepath.Join(realWorkspaceDirStr, ".lip"),
+		filepath.Join(realExistingPathStr, rest))
+	if err != nil {
+		return false, nil
+	}
+
+	return relPathStr == "." || (relPathStr != ".." &&
+		!strings.HasPrefix(relPathStr, ".."+string(filepath.Separator))), nil
+}
+
+// prepareCreation creates the missing parent directories of p in the workspace,
+// and returns the topmost path that did not exist, which is p or one of its
+// ancestors. An empty path is returned if p already exists.
+func prepareCreation(workspaceDir path.Path, p path.Path) (path.Path, error) {
+	if _, err := os.Lstat(p.LocalString()); err == nil {
+		return path.MakeEmpty(), nil
+	}
+
+	topmostPath := p
+	for {
+		dir, err := topmostPath.Dir()
+		if err != nil || !workspaceDir.IsAncestorOf(dir) {
+			break
+		}
+
+		if _, err := os.Lstat(dir.LocalString()); err == nil {
+			break
+		}
+
+		topmostPath = dir
+	}
+
+	parentDir, err := p.Dir()
+	if err != nil {
+		return path.Path{}, err
+	}
+
+	if err := os.MkdirAll(parentDir.LocalString(), 0755); err != nil {
+		return path.Path{}, fmt.Errorf("failed to create directory %v\n\t%w", parentDir.LocalString(), err)
+	}
+
+	return topmostPath, nil
+}
+
+// makeCreateEffects makes create effects of paths, skipping empty paths and paths
+// under other paths.
+func makeCreateEffects(workspaceDir path.Path, createdPaths ...path.Path) []effect {
+	effects := make([]effect, 0)
+
+	for i, createdPath := range createdPaths {
+		if createdPath.IsEmpty() {
+			continue
+		}
+
+		isUnderOther := false
+		for j, other := range createdPaths {
+			if i != j && !other.IsEmpty() && other.IsAncestorOf(createdPath) {
+				isUnderOther = true
+				break
+			}
+		}
+
+		if isUnderOther {
+			continue
+		}
+
+		effects = append(effects, effect{
+			Kind: effectCreate,
+			Path: createdPath.TrimPrefix(workspaceDir).String(),
+		})
+	}
+
+	return effects
+}
+
+// copyPath copies a file or a directory recursively. Existing files are overwritten.
+func copyPath(src string, dest string) error {
+	fileInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.IsDir() {
+		if err := os.MkdirAll(dest, fileInfo.Mode().Perm()); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := copyPath(filepath.Join(src, entry.Name()), filepath.Join(dest, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, srcFile)
+	return err
+}
+
+func writeFile(filePath path.Path, r io.Reader, createFile func(path.Path) (*os.File, error)) error {
+	if strings.HasPrefix(filePath.String(), "/") {
+		return fmt.Errorf("absolute path %v is not allowed in archives", filePath.String())
+	}
+
+	fw, err := createFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create %v\n\t%w", filePath.String(), err)
+	}
+	defer fw.Close()
+
+	if _, err := io.Copy(fw, r); err != nil {
+		return fmt.Errorf("failed to write %v\n\t%w", filePath.String(), err)
+	}
+
+	return nil
+}
+
+func readJSONObjectFile(filePath path.Path) (map[string]interface{}, error) {
+	jsonBytes, err := os.ReadFile(filePath.LocalString())
+	if os.IsNotExist(err) {
+		return make(map[string]interface{}), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	document := make(map[string]interface{})
+	if err := json.Unmarshal(jsonBytes, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse %v as a JSON object\n\t%w", filePath.LocalString(), err)
+	}
+
+	return document, nil
+}
+
+func writeJSONObjectFile(filePath path.Path, document map[string]interface{}) error {
+	jsonBytes, err := json.MarshalIndent(document, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON\n\t%w", err)
+	}
+
+	if err := os.WriteFile(filePath.LocalString(), jsonBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	return nil
+}
+
+// getJSONKey gets the value of a dot-separated key.
+func getJSONKey(document map[string]interface{}, key string) (interface{}, bool) {
+	keyItems := strings.Split(key, ".")
+
+	current := document
+	for i, keyItem := range keyItems {
+		value, ok := current[keyItem]
+		if !ok {
+			return nil, false
+		}
+
+		if i == len(keyItems)-1 {
+			return value, true
+		}
+
+		current, ok = value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// setJSONKey sets the value of a dot-separated key, creating missing objects.
+func setJSONKey(document map[string]interface{}, key string, value interface{}) error {
+	keyItems := strings.Split(key, ".")
+
+	current := document
+	for _, keyItem := range keyItems[:len(keyItems)-1] {
+		next, ok := current[keyItem]
+		if !ok {
+			next = make(map[string]interface{})
+			current[keyItem] = next
+		}
+
+		nextObject, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("key %v is not an object", keyItem)
+		}
+
+		current = nextObject
+	}
+
+	current[keyItems[len(keyItems)-1]] = value
+
+	return nil
+}
+
+// deleteJSONKey deletes a dot-separated key if it exists.
+func deleteJSONKey(document map[string]interface{}, key string) {
+	keyItems := strings.Split(key, ".")
+
+	current := document
+	for _, keyItem := range keyItems[:len(keyItems)-1] {
+		next, ok := current[keyItem].(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		current = next
+	}
+
+	delete(current, keyItems[len(keyItems)-1])
+}
+
+// mergeJSONObjects merges src into a copy of dest recursively.
+func mergeJSONObjects(dest map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for key, value := range dest {
+		merged[key] = value
+	}
+
+	for key, value := range src {
+		srcObject, isSrcObject := value.(map[string]interface{})
+		destObject, isDestObject := merged[key].(map[string]interface{})
+
+		if isSrcObject && isDestObject {
+			merged[key] = mergeJSONObjects(destObject, srcObject)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+// getContentDigest returns the digest of content in the form of sha256:<hex>.
+func getContentDigest(content []byte) string {
+	digest := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(digest[:])
+}
+
+// writeFileKeepingMode writes content to an existing file without changing its mode.
+func writeFileKeepingMode(filePath path.Path, content []byte) error {
+	fileInfo, err := os.Stat(filePath.LocalString())
+	if err != nil {
+		return fmt.Errorf("failed to stat %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	if err := os.WriteFile(filePath.LocalString(), content, fileInfo.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,310 @@
+package install
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+)
+
+// Conflict policies for files.place destinations that already exist.
+const (
+	// OnConflictBackup moves existing destinations into .lip/backup/<id>/.
+	OnConflictBackup = "backup"
+	// OnConflictOverwrite removes existing destinations.
+	OnConflictOverwrite = "overwrite"
+	// OnConflictSkip keeps existing destinations and does not place the files.
+	OnConflictSkip = "skip"
+	// OnConflictAbort fails the installation before touching any destination.
+	OnConflictAbort = "abort"
+)
+
+// Backup is a set of existing destinations moved aside during one run.
+type Backup struct {
+	ID        string        `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Entries   []BackupEntry `json:"entries"`
+}
+
+// BackupEntry is a destination moved aside when installing a tooth.
+type BackupEntry struct {
+	Tooth string `json:"tooth"`
+	// Path is the destination relative to the workspace.
+	Path string `json:"path"`
+}
+
+// ConflictResolver applies a conflict policy to the destinations of one run.
+// Destinations backed up in the run are moved into the same backup.
+type ConflictResolver struct {
+	policy        string
+	backupRootDir path.Path
+	backup        *Backup
+}
+
+// NewConflictResolver returns a ConflictResolver for one run with policy, one of
+// the OnConflict* policies.
+func NewConflictResolver(ctx *context.Context, policy string) (*ConflictResolver, error) {
+	switch policy {
+	case OnConflictBackup, OnConflictOverwrite, OnConflictSkip, OnConflictAbort:
+	default:
+		return nil, fmt.Errorf("invalid conflict policy %v, must be one of backup, overwrite, skip and abort", policy)
+	}
+
+	backupRootDir, err := ctx.BackupDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup directory\n\t%w", err)
+	}
+
+	return &ConflictResolver{
+		policy:        policy,
+		backupRootDir: backupRootDir,
+	}, nil
+}
+
+// Backup returns the backup made in the run, or nil if nothing was backed up.
+func (r *ConflictResolver) Backup() *Backup {
+	return r.backup
+}
+
+// ListBackups lists the backups of the workspace, oldest first.
+func ListBackups(ctx *context.Context) ([]Backup, error) {
+	backupRootDir, err := ctx.BackupDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup directory\n\t%w", err)
+	}
+
+	entries, err := os.ReadDir(backupRootDir.LocalString())
+	if os.IsNotExist(err) {
+		return []Backup{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory\n\t%w", err)
+	}
+
+	backups := make([]Backup, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		backup, err := readBackup(backupRootDir, entry.Name())
+		if err != nil {
+			log.Warnf("Skipping backup %v\n\t%v", entry.Name(), err)
+			continue
+		}
+
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID < backups[j].ID
+	})
+
+	return backups, nil
+}
+
+// RestoreBackup moves the destinations in a backup back into the workspace and
+// deletes the backup. If overwrite is false, it fails when a destination exists.
+func RestoreBackup(ctx *context.Context, id string, overwrite bool) error {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "install",
+		"method":  "RestoreBackup",
+	})
+
+	backupRootDir, err := ctx.BackupDir()
+	if err != nil {
+		return fmt.Errorf("failed to get backup directory\n\t%w", err)
+	}
+
+	if strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return fmt.Errorf("invalid backup ID %v", id)
+	}
+
+	backup, err := readBackup(backupRootDir, id)
+	if err != nil {
+		return err
+	}
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	backupDir := backupRootDir.Join(path.MustParse(id))
+
+	// Check all destinations before moving anything.
+	for _, entry := range backup.Entries {
+		dest, err := resolveWorkspacePath(workspaceDir, entry.Path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(dest.LocalString()); err == nil && !overwrite {
+			return fmt.Errorf("%v already exists, uninstall tooth %v first or pass --overwrite", entry.Path, entry.Tooth)
+		}
+	}
+
+	for _, entry := range backup.Entries {
+		dest, err := resolveWorkspacePath(workspaceDir, entry.Path)
+		if err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(dest.LocalString()); err != nil {
+			return fmt.Errorf("failed to remove %v\n\t%w", entry.Path, err)
+		}
+
+		if err := movePath(backupDir.Join(path.MustParse("files")).Join(path.MustParse(entry.Path)), dest); err != nil {
+			return fmt.Errorf("failed to restore %v\n\t%w", entry.Path, err)
+		}
+
+		log.Infof("Restored %v", entry.Path)
+	}
+
+	if err := os.RemoveAll(backupDir.LocalString()); err != nil {
+		return fmt.Errorf("failed to delete backup %v\n\t%w", id, err)
+	}
+
+	debugLogger.Debugf("Deleted backup %v", id)
+
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// backUp moves a destination of a tooth into the backup of the run, creating the
+// backup on first use.
+func (r *ConflictResolver) backUp(workspaceDir path.Path, toothRepoPath string, relDest path.Path) error {
+	if r.backup == nil {
+		backup, err := createBackup(r.backupRootDir)
+		if err != nil {
+			return err
+		}
+
+		r.backup = &backup
+	}
+
+	backupDir := r.backupRootDir.Join(path.MustParse(r.backup.ID))
+
+	if err := movePath(workspaceDir.Join(relDest), backupDir.Join(path.MustParse("files")).Join(relDest)); err != nil {
+		return fmt.Errorf("failed to back up %v\n\t%w", relDest.LocalString(), err)
+	}
+
+	r.backup.Entries = append(r.backup.Entries, BackupEntry{
+		Tooth: toothRepoPath,
+		Path:  relDest.String(),
+	})
+
+	return saveBackup(r.backupRootDir, *r.backup)
+}
+
+// createBackup creates an empty backup named after the current time.
+func createBackup(backupRootDir path.Path) (Backup, error) {
+	if err := os.MkdirAll(backupRootDir.LocalString(), 0755); err != nil {
+		return Backup{}, fmt.Errorf("failed to create backup directory\n\t%w", err)
+	}
+
+	now := time.Now()
+	baseID := now.UTC().Format("20060102T150405Z")
+
+	// Runs within the same second get suffixed IDs.
+	for i := 0; ; i++ {
+		id := baseID
+		if i != 0 {
+			id = fmt.Sprintf("%v-%v", baseID, i)
+		}
+
+		err := os.Mkdir(backupRootDir.Join(path.MustParse(id)).LocalString(), 0755)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return Backup{}, fmt.Errorf("failed to create backup %v\n\t%w", id, err)
+		}
+
+		return Backup{
+			ID:        id,
+			CreatedAt: now,
+			Entries:   make([]BackupEntry, 0),
+		}, nil
+	}
+}
+
+func getBackupManifestPath(backupRootDir path.Path, id string) path.Path {
+	return backupRootDir.Join(path.MustParse(id)).Join(path.MustParse("backup.json"))
+}
+
+// movePath moves a file or a directory, creating the parent directories of dest.
+// It falls back to copying across file systems.
+func movePath(src path.Path, dest path.Path) error {
+	destDir, err := dest.Dir()
+	if err != nil {
+		return fmt.Errorf("failed to parse directory\n\t%w", err)
+	}
+
+	if err := os.MkdirAll(destDir.LocalString(), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %v\n\t%w", destDir.LocalString(), err)
+	}
+
+	if err := os.Rename(src.LocalString(), dest.LocalString()); err == nil {
+		return nil
+	}
+
+	if err := copyPath(src.LocalString(), dest.LocalString()); err != nil {
+		return fmt.Errorf("failed to copy %v to %v\n\t%w", src.LocalString(), dest.LocalString(), err)
+	}
+
+	return os.RemoveAll(src.LocalString())
+}
+
+func readBackup(backupRootDir path.Path, id string) (Backup, error) {
+	manifestPath := getBackupManifestPath(backupRootDir, id)
+
+	jsonBytes, err := os.ReadFile(manifestPath.LocalString())
+	if os.IsNotExist(err) {
+		return Backup{}, fmt.Errorf("backup %v does not exist", id)
+	} else if err != nil {
+		return Backup{}, fmt.Errorf("failed to read backup manifest %v\n\t%w", manifestPath.LocalString(), err)
+	}
+
+	var backup Backup
+	if err := json.Unmarshal(jsonBytes, &backup); err != nil {
+		return Backup{}, fmt.Errorf("failed to parse backup manifest %v\n\t%w", manifestPath.LocalString(), err)
+	}
+
+	return backup, nil
+}
+
+// saveBackup writes the manifest of a backup atomically.
+func saveBackup(backupRootDir path.Path, backup Backup) error {
+	jsonBytes, err := json.MarshalIndent(backup, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest\n\t%w", err)
+	}
+
+	manifestPath := getBackupManifestPath(backupRootDir, backup.ID)
+
+	tempFile, err := os.CreateTemp(filepath.Dir(manifestPath.LocalString()), "backup-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file\n\t%w", err)
+	}
+
+	_, err = tempFile.Write(jsonBytes)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write backup manifest\n\t%w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), manifestPath.LocalString()); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to save backup manifest\n\t%w", err)
+	}
+
+	return nil
+}
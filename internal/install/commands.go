@@ -2,43 +2,354 @@ package install
 
 import (
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/blang/semver/v4"
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/network"
+	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/prompt"
+	"github.com/lippkg/lip/internal/tooth"
 	log "github.com/sirupsen/logrus"
 )
 
-// runCommands runs the given commands.
-func runCommands(commands []string, environs map[string]string) error {
+// isCommandRunAllowed reviews the commands of a tooth against the script policy
+// and returns whether they are allowed to run. Shell commands and destructive
+// actions are subject to the policy, and all commands and actions are shown
+// before a decision is made. allowScripts approves them under the prompt policy.
+func isCommandRunAllowed(ctx *context.Context, toothRepoPath string, commandsByPhase map[string][]tooth.CommandsItem,
+	phases []string, allowScripts bool, ignoreScripts bool) (bool, error) {
+
+	reviewedCount := 0
+	for _, phase := range phases {
+		for _, item := range commandsByPhase[phase] {
+			if item.Action == nil || isDestructiveAction(*item.Action) {
+				reviewedCount++
+			}
+		}
+	}
+
+	// Actions creating files only are reverted on uninstall and need no review.
+	if reviewedCount == 0 {
+		return true, nil
+	}
+
+	if ignoreScripts {
+		log.Warnf("Skipping commands of tooth %v because --ignore-scripts is set", toothRepoPath)
+		return false, nil
+	}
+
+	scriptPolicy, err := ctx.ScriptPolicy()
+	if err != nil {
+		return false, fmt.Errorf("failed to get script policy\n\t%w", err)
+	}
+
+	log.Infof("Tooth %v declares the following commands:", toothRepoPath)
+	for _, phase := range phases {
+		for _, item := range commandsByPhase[phase] {
+			if item.Action != nil {
+				log.Infof("  [%v] (action) %v", phase, describeAction(*item.Action))
+			} else {
+				log.Infof("  [%v] %v", phase, item.Command)
+			}
+		}
+	}
+
+	isTrusted := isTrustedTooth(ctx, toothRepoPath)
+
+	switch scriptPolicy {
+	case context.ScriptPolicyAlways:
+		return true, nil
+
+	case context.ScriptPolicyNever:
+		log.Warnf("Skipping commands of tooth %v because the script policy is %v", toothRepoPath, scriptPolicy)
+		return false, nil
+
+	case context.ScriptPolicyTrustedAuthors:
+		if !isTrusted {
+			log.Warnf("Skipping commands of tooth %v because it is not in trusted_teeth", toothRepoPath)
+			return false, nil
+		}
+		return true, nil
+
+	case context.ScriptPolicyPrompt:
+		if isTrusted || allowScripts {
+			return true, nil
+		}
+
+		ok, err := prompt.Confirm(ctx, "Do you want to run these commands?", false,
+			"--allow-scripts or --ignore-scripts")
+		if err != nil {
+			return false, err
+		}
+
+		if !ok {
+			log.Warnf("Skipping commands of tooth %v. The tooth might not work as expected", toothRepoPath)
+			return false, nil
+		}
+		return true, nil
+
+	default:
+		panic("unreachable")
+	}
+}
+
+// isTrustedTooth checks if the tooth repo path matches an entry of trusted_teeth.
+// An entry matches the tooth repo path itself and any path under it.
+func isTrustedTooth(ctx *context.Context, toothRepoPath string) bool {
+	for _, trustedTooth := range ctx.TrustedTeeth() {
+		trustedTooth = strings.TrimSuffix(trustedTooth, "/")
+		if trustedTooth == "" {
+			continue
+		}
+
+		if toothRepoPath == trustedTooth || strings.HasPrefix(toothRepoPath, trustedTooth+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hookEnvironment describes a run of the commands of a tooth in a phase.
+type hookEnvironment struct {
+	toothRepoPath string
+	version       semver.Version
+	// oldVersion is the version being replaced when upgrading or reinstalling.
+	// It is empty otherwise.
+	oldVersion string
+	phase      string
+}
+
+// WorkspaceEnvirons makes the environment variables of the workspace passed to
+// commands declared by teeth and to executables run by lip exec. The shims of
+// executables are found in PATH.
+func WorkspaceEnvirons(ctx *context.Context) ([]string, error) {
+	environs := os.Environ()
+
+	// Commands make the same proxy decision as lip.
+	networkOptions, err := ctx.NetworkOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network options\n\t%w", err)
+	}
+
+	environs = append(environs, network.ProxyEnv(networkOptions)...)
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	cacheDir, err := ctx.CacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache directory\n\t%w", err)
+	}
+
+	binDir, err := ctx.BinDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bin directory\n\t%w", err)
+	}
+
+	// Later values override earlier ones when running commands.
+	environs = append(environs,
+		fmt.Sprintf("LIP_WORKSPACE=%v", workspaceDir.LocalString()),
+		fmt.Sprintf("LIP_CACHE_DIR=%v", cacheDir.LocalString()),
+		fmt.Sprintf("LIP_GOOS=%v", runtime.GOOS),
+		fmt.Sprintf("LIP_GOARCH=%v", runtime.GOARCH),
+		fmt.Sprintf("PATH=%v%c%v", binDir.LocalString(), os.PathListSeparator, os.Getenv("PATH")),
+	)
+
+	return environs, nil
+}
+
+// makeCommandEnvirons makes the environment variables passed to commands.
+func makeCommandEnvirons(ctx *context.Context, hookEnv hookEnvironment) ([]string, error) {
+	environs, err := WorkspaceEnvirons(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	environs = append(environs,
+		fmt.Sprintf("LIP_TOOTH=%v", hookEnv.toothRepoPath),
+		fmt.Sprintf("LIP_VERSION=%v", hookEnv.version.String()),
+		fmt.Sprintf("LIP_OLD_VERSION=%v", hookEnv.oldVersion),
+		fmt.Sprintf("LIP_PHASE=%v", hookEnv.phase),
+	)
+
+	return environs, nil
+}
+
+// runCommands runs the given commands and actions in the workspace directory.
+// Shell commands and destructive actions are skipped if allowed is false. The output is also
+// written to .lip/logs/<tooth>-<phase>.log. The effects of the actions are returned.
+func runCommands(ctx *context.Context, items []tooth.CommandsItem, hookEnv hookEnvironment,
+	allowed bool) ([]effect, error) {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "install",
 		"method":  "runCommands",
 	})
 
-	for _, command := range commands {
-		var cmd *exec.Cmd
-		switch runtime.GOOS {
-		case "windows":
-			cmd = exec.Command("cmd", "/C", command)
-		default:
-			cmd = exec.Command("sh", "-c", command)
-		}
+	if len(items) == 0 {
+		return nil, nil
+	}
 
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	environs, err := makeCommandEnvirons(ctx, hookEnv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make command environment variables\n\t%w", err)
+	}
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	hookTimeout, err := ctx.HookTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hook timeout\n\t%w", err)
+	}
+
+	logsDir, err := ctx.LogsDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs directory\n\t%w", err)
+	}
 
-		for key, value := range environs {
-			cmd.Env = append(os.Environ(), fmt.Sprintf("%v=%v", key, value))
+	logFileName := fmt.Sprintf("%v-%v.log", url.QueryEscape(hookEnv.toothRepoPath), hookEnv.phase)
+	logFilePath := logsDir.Join(path.MustParse(logFileName))
+
+	logFile, err := os.Create(logFilePath.LocalString())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file %v\n\t%w", logFilePath.LocalString(), err)
+	}
+	defer logFile.Close()
+
+	// Commands must not wait for input that will never come.
+	var stdin io.Reader
+	if ctx.IsInteractive() {
+		stdin = os.Stdin
+	}
+
+	effects := make([]effect, 0)
+
+	for _, item := range items {
+		if item.Action != nil {
+			description := describeAction(*item.Action)
+
+			if !allowed && isDestructiveAction(*item.Action) {
+				debugLogger.Debugf("Skipped action %v", description)
+				continue
+			}
+
+			fmt.Fprintf(logFile, "# %v\n", description)
+
+			actionEffects, err := runAction(workspaceDir, *item.Action)
+			if err != nil {
+				fmt.Fprintf(logFile, "%v\n", err)
+				return nil, fmt.Errorf("failed to run action %v\n\t%w", description, err)
+			}
+
+			effects = append(effects, actionEffects...)
+
+			debugLogger.Debugf("Ran action %v", description)
+			continue
 		}
 
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to run command %v\n\t%w", command, err)
+		if !allowed {
+			debugLogger.Debugf("Skipped command %v", item.Command)
+			continue
 		}
 
-		debugLogger.Debugf("Ran command %v", command)
+		if err := runCommand(item.Command, environs, workspaceDir, hookTimeout, stdin, logFile); err != nil {
+			return nil, fmt.Errorf("failed to run command %v, see %v for details\n\t%w", item.Command,
+				logFilePath.LocalString(), err)
+		}
+
+		debugLogger.Debugf("Ran command %v", item.Command)
+	}
+
+	return effects, nil
+}
+
+// runCommand runs a command with a shell. A zero timeout means no timeout. A nil
+// stdin means the null device. The command runs in its own process group, so that
+// the processes it spawns are killed with it on timeout or interrupt.
+func runCommand(command string, environs []string, workDir path.Path, timeout time.Duration,
+	stdin io.Reader, logWriter io.Writer) error {
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("cmd", "/C", command)
+	default:
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	cmd.Dir = workDir.LocalString()
+	cmd.Env = environs
+	cmd.Stdin = stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, logWriter)
+	cmd.Stderr = io.MultiWriter(os.Stderr, logWriter)
+
+	fmt.Fprintf(logWriter, "$ %v\n", command)
+
+	// Interrupts reaching lip stop the command as well.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	restoreForeground, err := startProcessGroup(cmd)
+	if err != nil {
+		fmt.Fprintf(logWriter, "%v\n", err)
+		return err
+	}
+	defer restoreForeground()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case err = <-done:
+
+	case <-timeoutChan:
+		stopProcessGroup(cmd, done)
+		fmt.Fprintf(logWriter, "timed out after %v\n", timeout)
+		return fmt.Errorf("timed out after %v", timeout)
+
+	case <-interrupts:
+		stopProcessGroup(cmd, done)
+		fmt.Fprintf(logWriter, "interrupted\n")
+		return fmt.Errorf("interrupted")
+	}
+
+	if err != nil {
+		fmt.Fprintf(logWriter, "%v\n", err)
+		return err
 	}
 
 	return nil
 }
+
+// stopProcessGroup kills the process group of cmd and waits for cmd to exit.
+func stopProcessGroup(cmd *exec.Cmd, done <-chan error) {
+	if err := killProcessGroup(cmd); err != nil {
+		log.Warnf("Failed to kill processes of command\n\t%v", err)
+		cmd.Process.Kill()
+	}
+
+	<-done
+}
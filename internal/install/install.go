@@ -1,56 +1,81 @@
 package install
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/gzip"
 	"fmt"
-	"io"
-	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/lippkg/lip/internal/archive"
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/path"
 	"github.com/lippkg/lip/internal/tooth"
 	log "github.com/sirupsen/logrus"
 )
 
-// Install installs a tooth archive with an asset archive. If assetArchiveFilePath is empty,
-// will use the tooth archive as the asset archive.
-func Install(ctx *context.Context, archive tooth.Archive, yes bool) error {
+// Install installs a tooth archive with an asset archive and records it in the
+// installed store. If assetArchiveFilePath is empty, will use the tooth archive as
+// the asset archive. previous is the record of the tooth being replaced when
+// upgrading or reinstalling, or nil otherwise. reason is one of the install
+// reasons, and an explicit previous reason is kept together with its specifier.
+// provenance describes where the tooth came from. conflictResolver decides what
+// to do with destinations that already exist. If allowScripts is true, commands
+// declared by the tooth are run without asking under the prompt script policy. If
+// ignoreScripts is true, they will not be run.
+func Install(ctx *context.Context, installedStore *tooth.InstalledStore, archive tooth.Archive,
+	previous *tooth.InstalledTooth, reason string, provenance tooth.Provenance,
+	conflictResolver *ConflictResolver, allowScripts bool, ignoreScripts bool) error {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "install",
 		"method":  "Install",
 	})
 
-	commandEnvirons := make(map[string]string)
+	// 1. Check if the tooth is already installed.
+
+	if installedStore.IsInstalled(archive.Metadata().ToothRepoPath()) {
+		return fmt.Errorf("tooth %v is already installed", archive.Metadata().ToothRepoPath())
+	}
+	debugLogger.Debug("Checked if tooth is already installed")
 
-	proxyURL, err := ctx.ProxyURL()
+	// Paths under named roots are recorded expanded, so that uninstalling removes
+	// the files placed even if the roots change.
+	roots, err := ctx.Roots()
 	if err != nil {
-		return fmt.Errorf("failed to get proxy URL\n\t%w", err)
+		return fmt.Errorf("failed to get roots\n\t%w", err)
 	}
 
-	if proxyURL.String() != "" {
-		commandEnvirons = map[string]string{
-			"HTTP_PROXY":  proxyURL.String(),
-			"HTTPS_PROXY": proxyURL.String(),
-		}
+	metadata, err := archive.Metadata().ToRootsExpanded(roots)
+	if err != nil {
+		return fmt.Errorf("failed to expand roots\n\t%w", err)
 	}
 
-	// 1. Check if the tooth is already installed.
+	if err := checkShims(ctx, installedStore, metadata); err != nil {
+		return err
+	}
 
-	if installed, err := tooth.IsInstalled(ctx, archive.Metadata().ToothRepoPath()); err != nil {
-		return fmt.Errorf("failed to check if tooth is installed\n\t%w", err)
-	} else if installed {
-		return fmt.Errorf("tooth %v is already installed", archive.Metadata().ToothRepoPath())
+	oldVersion := ""
+	if previous != nil {
+		oldVersion = previous.Metadata.Version().String()
 	}
-	debugLogger.Debug("Checked if tooth is already installed")
 
-	// 2. Run pre-install commands.
+	// 2. Review commands and run pre-install commands.
+
+	commands := metadata.Commands()
+	shouldRunCommands, err := isCommandRunAllowed(ctx, metadata.ToothRepoPath(), map[string][]tooth.CommandsItem{
+		"pre_install":  commands.PreInstall,
+		"post_install": commands.PostInstall,
+	}, []string{"pre_install", "post_install"}, allowScripts, ignoreScripts)
+	if err != nil {
+		return fmt.Errorf("failed to review commands\n\t%w", err)
+	}
 
-	if err := runCommands(archive.Metadata().Commands().PreInstall, commandEnvirons); err != nil {
+	effects, err := runCommands(ctx, commands.PreInstall, hookEnvironment{
+		toothRepoPath: metadata.ToothRepoPath(),
+		version:       metadata.Version(),
+		oldVersion:    oldVersion,
+		phase:         "pre_install",
+	}, shouldRunCommands)
+	if err != nil {
 		return fmt.Errorf("failed to run pre-install commands\n\t%w", err)
 	}
 	debugLogger.Debug("Ran pre-install commands")
@@ -62,230 +87,177 @@ func Install(ctx *context.Context, archive tooth.Archive, yes bool) error {
 		return fmt.Errorf("failed to get asset file path of archive %v\n\t%w", archive.FilePath().LocalString(), err)
 	}
 
-	if err := placeFiles(ctx, archive.Metadata(), assetFilePath, yes); err != nil {
+	placedDests, err := placeFiles(ctx, metadata, assetFilePath, conflictResolver)
+	if err != nil {
 		return fmt.Errorf("failed to place files\n\t%w", err)
 	}
 	debugLogger.Debug("Placed files")
 
+	// Shims are created before post-install commands, which may run them.
+	if err := createShims(ctx, metadata); err != nil {
+		return fmt.Errorf("failed to create shims\n\t%w", err)
+	}
+	debugLogger.Debug("Created shims")
+
 	// 4. Run post-install commands.
 
-	if err := runCommands(archive.Metadata().Commands().PostInstall, commandEnvirons); err != nil {
+	postInstallEffects, err := runCommands(ctx, commands.PostInstall, hookEnvironment{
+		toothRepoPath: metadata.ToothRepoPath(),
+		version:       metadata.Version(),
+		oldVersion:    oldVersion,
+		phase:         "post_install",
+	}, shouldRunCommands)
+	if err != nil {
 		return fmt.Errorf("failed to run post-install commands\n\t%w", err)
 	}
 	debugLogger.Debug("Ran post-install commands")
 
-	// 5. Create metadata file.
+	effects = append(effects, postInstallEffects...)
 
-	jsonBytes, err := archive.Metadata().MarshalJSON()
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata\n\t%w", err)
+	if err := saveEffects(ctx, metadata.ToothRepoPath(), effects); err != nil {
+		return fmt.Errorf("failed to save effects of actions\n\t%w", err)
 	}
 
-	metadataFileName := url.QueryEscape(archive.Metadata().ToothRepoPath()) + ".json"
-	metadataDir, err := ctx.MetadataDir()
-	if err != nil {
-		return fmt.Errorf("failed to get metadata directory\n\t%w", err)
+	// 5. Record the tooth as installed.
+
+	// Skipped destinations are not recorded, so that they are not deleted on
+	// uninstall.
+	installedTooth := tooth.InstalledTooth{
+		Metadata:    metadata,
+		Reason:      reason,
+		InstalledAt: time.Now(),
+		UpdatedAt:   time.Now(),
+		Files:       placedDests,
+		Provenance:  provenance,
 	}
 
-	metadataPath := metadataDir.Join(path.MustParse(metadataFileName))
+	if previous != nil {
+		installedTooth.InstalledAt = previous.InstalledAt
+		if previous.Reason == tooth.InstallReasonExplicit {
+			installedTooth.Reason = tooth.InstallReasonExplicit
+			if installedTooth.Provenance.Specifier == "" {
+				installedTooth.Provenance.Specifier = previous.Provenance.Specifier
+			}
+		}
+	}
 
-	if err := os.WriteFile(metadataPath.LocalString(), jsonBytes, 0644); err != nil {
-		return fmt.Errorf("failed to create metadata file\n\t%w", err)
+	if err := installedStore.Put(installedTooth); err != nil {
+		return fmt.Errorf("failed to record installed tooth\n\t%w", err)
 	}
 
-	debugLogger.Debugf("Created metadata file %v", metadataPath.LocalString())
+	debugLogger.Debugf("Recorded tooth %v as installed", metadata.ToothRepoPath())
 
 	return nil
 }
 
-// placeFiles places the files of the tooth.
-func placeFiles(ctx *context.Context, metadata tooth.Metadata, assetArchiveFilePath path.Path, forcePlace bool) error {
+// placeFiles places the files of the tooth and returns the destinations placed.
+// Destinations that already exist are resolved by conflictResolver, all before
+// extracting any file.
+func placeFiles(ctx *context.Context, metadata tooth.Metadata, assetArchiveFilePath path.Path,
+	conflictResolver *ConflictResolver) ([]path.Path, error) {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "install",
 		"method":  "placeFiles",
 	})
 
-	workspaceDirStr, err := os.Getwd()
+	workspaceDir, err := ctx.WorkspaceDir()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to get workspace directory\n\t%w", err)
 	}
 
-	workspaceDir, err := path.Parse(workspaceDirStr)
+	files, err := metadata.Files()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to get files from metadata\n\t%w", err)
 	}
 
-	if strings.HasSuffix(assetArchiveFilePath.LocalString(), ".zip") {
-		// Open the archive.
-		r, err := zip.OpenReader(assetArchiveFilePath.LocalString())
-		if err != nil {
-			return fmt.Errorf("failed to open zip reader\n\t%w", err)
-		}
-		defer r.Close()
-
-		files, err := metadata.Files()
-		if err != nil {
-			return fmt.Errorf("failed to get files from metadata\n\t%w", err)
-		}
-
-		for _, place := range files.Place {
-			relDest := place.Dest
-
-			// Check if the destination exists.
-			if _, err := os.Stat(relDest.LocalString()); err == nil {
-				if !forcePlace {
-					// Ask for confirmation.
-					log.Infof("Destination %v already exists", relDest.LocalString())
-					log.Info("Do you want to remove? [y/N]")
-					var ans string
-					fmt.Scanln(&ans)
-					if ans != "y" && ans != "Y" {
-						return fmt.Errorf("aborted")
-					}
-				}
-
-				log.Infof("Removing destination %v", relDest.LocalString())
-
-				// Remove the destination if it exists.
-				if err := os.RemoveAll(relDest.LocalString()); err != nil {
-					return fmt.Errorf("failed to remove destination %v\n\t%w", relDest.LocalString(), err)
-				}
-			}
-
-			dest := workspaceDir.Join(relDest)
-
-			// Create the destination directory.
-			if err := os.MkdirAll(filepath.Dir(dest.LocalString()), 0755); err != nil {
-				return fmt.Errorf("failed to create destination directory\n\t%w", err)
-			}
-			debugLogger.Debugf("Created destination directory %v", filepath.Dir(dest.LocalString()))
-
-			// Iterate through the files in the archive,
-			// and find the source file.
-			for _, f := range r.File {
-				// Skip directories.
-				if strings.HasSuffix(f.Name, "/") {
-					debugLogger.Debugf("Skipped %v because it is a directory", f.Name)
-
-					continue
-				}
-
-				filePath, err := path.Parse(f.Name)
-				if err != nil {
-					return fmt.Errorf("failed to parse file path from %v\n\t%w", f.Name, err)
-				}
-
-				if filePath.Equal(place.Src) {
-					// Open the source file.
-					rc, err := f.Open()
-					if err != nil {
-						return fmt.Errorf("failed to open source file\n\t%w", err)
-					}
-
-					fw, err := os.Create(dest.LocalString())
-					if err != nil {
-						return fmt.Errorf("failed to create destination file\n\t%w", err)
-					}
+	assetURL, err := metadata.AssetURL()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset URL\n\t%w", err)
+	}
 
-					// Copy the file.
-					if _, err := io.Copy(fw, rc); err != nil {
-						return fmt.Errorf("failed to copy file\n\t%w", err)
-					}
+	// A raw or compressed single file asset is named after the asset URL.
+	r, err := archive.Open(assetArchiveFilePath, archive.RawFileName(assetURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open asset archive %v\n\t%w", assetArchiveFilePath.LocalString(), err)
+	}
+	defer r.Close()
 
-					// Close the files.
-					rc.Close()
-					fw.Close()
+	// Check that all sources exist before touching any destination.
+	assetFilePathSet := make(map[string]bool)
+	for _, filePath := range r.FilePaths() {
+		assetFilePathSet[filePath.String()] = true
+	}
 
-					debugLogger.Debugf("Placed file %v to %v", f.Name, dest.LocalString())
-				}
-			}
-		}
-	} else if strings.HasSuffix(assetArchiveFilePath.LocalString(), ".tar.gz") {
-		file, err := os.Open(assetArchiveFilePath.LocalString())
-		if err != nil {
-			return fmt.Errorf("failed to open %s\n\t%w", assetArchiveFilePath.LocalString(), err)
+	for _, place := range files.Place {
+		if !assetFilePathSet[place.Src.String()] {
+			return nil, fmt.Errorf("source %v of files.place does not exist in the asset", place.Src.LocalString())
 		}
-		gzr, err := gzip.NewReader(file)
-		if err != nil {
-			return fmt.Errorf("failed to open %s\n\t%w", assetArchiveFilePath.LocalString(), err)
-		}
-		gzr.Close()
 
-		tarR := tar.NewReader(gzr)
-		files, err := metadata.Files()
-		if err != nil {
-			return fmt.Errorf("failed to get files from metadata\n\t%w", err)
+		if _, err := resolveWorkspacePath(workspaceDir, place.Dest.String()); err != nil {
+			return nil, fmt.Errorf("invalid destination of files.place\n\t%w", err)
 		}
+	}
 
-		for _, place := range files.Place {
-			relDest := place.Dest
-
-			// Check if the destination exists.
-			if _, err := os.Stat(relDest.LocalString()); err == nil {
-				if !forcePlace {
-					// Ask for confirmation.
-					log.Infof("Destination %v already exists", relDest.LocalString())
-					log.Info("Do you want to remove? [y/N]")
-					var ans string
-					fmt.Scanln(&ans)
-					if ans != "y" && ans != "Y" {
-						return fmt.Errorf("aborted")
-					}
-				}
+	// Find existing destinations.
+	conflicts := make(map[string]bool)
+	conflictStrings := make([]string, 0)
+	for _, place := range files.Place {
+		if _, err := os.Lstat(workspaceDir.Join(place.Dest).LocalString()); err == nil && !conflicts[place.Dest.String()] {
+			conflicts[place.Dest.String()] = true
+			conflictStrings = append(conflictStrings, place.Dest.LocalString())
+		}
+	}
 
-				log.Infof("Removing destination %v", relDest.LocalString())
+	if len(conflicts) != 0 && conflictResolver.policy == OnConflictAbort {
+		return nil, fmt.Errorf("destinations already exist: %v. Pass --on-conflict=backup, overwrite or skip to resolve",
+			strings.Join(conflictStrings, ", "))
+	}
 
-				// Remove the destination if it exists.
-				if err := os.RemoveAll(relDest.LocalString()); err != nil {
-					return fmt.Errorf("failed to remove destination %v\n\t%w", relDest.LocalString(), err)
-				}
-			}
+	dests := make(map[string][]path.Path)
+	placedDests := make([]path.Path, 0, len(files.Place))
+	resolved := make(map[string]bool)
 
-			dest := workspaceDir.Join(relDest)
+	for _, place := range files.Place {
+		relDest := place.Dest
 
-			// Create the destination directory.
-			if err := os.MkdirAll(filepath.Dir(dest.LocalString()), 0755); err != nil {
-				return fmt.Errorf("failed to create destination directory\n\t%w", err)
+		if conflicts[relDest.String()] {
+			if conflictResolver.policy == OnConflictSkip {
+				log.Infof("Skipping existing destination %v", relDest.LocalString())
+				continue
 			}
-			debugLogger.Debugf("Created destination directory %v", filepath.Dir(dest.LocalString()))
-
-			// Iterate through the files in the archive,
-			// and find the source file.
-			for f, err := tarR.Next(); err != io.EOF; f, err = tarR.Next() {
-				if err != nil {
-					return fmt.Errorf("failed to read tar\n\t%w", err)
-				}
-				// Skip directories.
-				if f.Typeflag == tar.TypeDir {
-					debugLogger.Debugf("Skipped %v because it is a directory", f.Name)
-
-					continue
-				}
 
-				filePath, err := path.Parse(f.Name)
-				if err != nil {
-					return fmt.Errorf("failed to parse file path from %v\n\t%w", f.Name, err)
-				}
-				if filePath.Equal(place.Src) {
-					// Open the source file.
-					fw, err := os.Create(dest.LocalString())
-					if err != nil {
-						return fmt.Errorf("failed to create destination file\n\t%w", err)
-					}
+			if !resolved[relDest.String()] {
+				switch conflictResolver.policy {
+				case OnConflictBackup:
+					log.Infof("Backing up existing destination %v", relDest.LocalString())
 
-					// Copy the file.
-					if _, err := io.Copy(fw, tarR); err != nil {
-						return fmt.Errorf("failed to copy file\n\t%w", err)
+					if err := conflictResolver.backUp(workspaceDir, metadata.ToothRepoPath(), relDest); err != nil {
+						return nil, err
 					}
 
-					// Close the files.
-					fw.Close()
+				case OnConflictOverwrite:
+					log.Infof("Removing existing destination %v", relDest.LocalString())
 
-					debugLogger.Debugf("Placed file %v to %v", f.Name, dest.LocalString())
+					if err := os.RemoveAll(workspaceDir.Join(relDest).LocalString()); err != nil {
+						return nil, fmt.Errorf("failed to remove destination %v\n\t%w", relDest.LocalString(), err)
+					}
 				}
+
+				resolved[relDest.String()] = true
 			}
 		}
+
+		dest := workspaceDir.Join(relDest)
+		dests[place.Src.String()] = append(dests[place.Src.String()], dest)
+		placedDests = append(placedDests, relDest)
 	}
 
-	return nil
+	// Extract all files in one pass.
+	if err := r.Extract(dests); err != nil {
+		return nil, fmt.Errorf("failed to extract files\n\t%w", err)
+	}
+
+	debugLogger.Debugf("Placed %v files", len(placedDests))
+
+	return placedDests, nil
 }
@@ -0,0 +1,49 @@
+//go:build !windows
+
+package install
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// startProcessGroup starts cmd in a new process group. If stdin of cmd is a
+// terminal, the group is moved to the foreground so that it can read from the
+// terminal, and the returned function moves lip back to the foreground.
+func startProcessGroup(cmd *exec.Cmd) (func(), error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	restoreForeground := func() {}
+
+	if stdinFile, ok := cmd.Stdin.(*os.File); ok && term.IsTerminal(int(stdinFile.Fd())) {
+		ttyFd := int(stdinFile.Fd())
+
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = ttyFd
+
+		restoreForeground = func() {
+			// A process group in the background gets SIGTTOU when taking the terminal.
+			signal.Ignore(unix.SIGTTOU)
+			defer signal.Reset(unix.SIGTTOU)
+
+			unix.IoctlSetPointerInt(ttyFd, unix.TIOCSPGRP, unix.Getpgrp())
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		restoreForeground()
+		return func() {}, err
+	}
+
+	return restoreForeground, nil
+}
+
+// killProcessGroup kills the process group started by startProcessGroup.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package install
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// startProcessGroup starts cmd. Processes it spawns are found by taskkill /T.
+func startProcessGroup(cmd *exec.Cmd) (func(), error) {
+	return func() {}, cmd.Start()
+}
+
+// killProcessGroup kills cmd and the processes it spawned.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+}
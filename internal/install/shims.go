@@ -0,0 +1,284 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	"github.com/lippkg/lip/internal/tooth"
+	log "github.com/sirupsen/logrus"
+)
+
+// shimMarker is written into every shim, so that lip never deletes or overwrites
+// files it did not create.
+const shimMarker = "Created by lip for "
+
+// LookupExecutable finds the installed tooth declaring the executable name and
+// returns the path of the executable.
+func LookupExecutable(ctx *context.Context, installedStore *tooth.InstalledStore, name string) (path.Path, error) {
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return path.Path{}, fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	for _, metadata := range installedStore.All() {
+		bin, err := metadata.Bin()
+		if err != nil {
+			return path.Path{}, fmt.Errorf("failed to get executables of tooth %v\n\t%w", metadata.ToothRepoPath(), err)
+		}
+
+		if target, ok := bin[name]; ok {
+			return workspaceDir.Join(target), nil
+		}
+	}
+
+	return path.Path{}, fmt.Errorf("no installed tooth declares executable %v", name)
+}
+
+// ---------------------------------------------------------------------
+
+// checkShims checks that the shims of the executables of the tooth can be created,
+// before anything is installed.
+func checkShims(ctx *context.Context, installedStore *tooth.InstalledStore, metadata tooth.Metadata) error {
+	bin, err := metadata.Bin()
+	if err != nil {
+		return fmt.Errorf("failed to get executables\n\t%w", err)
+	}
+
+	if len(bin) == 0 {
+		return nil
+	}
+
+	// Executables share the bin directory, so their names must be unique.
+	for _, other := range installedStore.All() {
+		otherBin, err := other.Bin()
+		if err != nil {
+			return fmt.Errorf("failed to get executables of tooth %v\n\t%w", other.ToothRepoPath(), err)
+		}
+
+		for name := range bin {
+			if _, ok := otherBin[name]; ok {
+				return fmt.Errorf("executable %v is already declared by tooth %v", name, other.ToothRepoPath())
+			}
+		}
+	}
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	binDir, err := ctx.BinDir()
+	if err != nil {
+		return fmt.Errorf("failed to get bin directory\n\t%w", err)
+	}
+
+	for name, target := range bin {
+		if _, err := resolveWorkspacePath(workspaceDir, target.String()); err != nil {
+			return fmt.Errorf("invalid path of executable %v\n\t%w", name, err)
+		}
+
+		shimPath, err := getShimPath(binDir, name)
+		if err != nil {
+			return err
+		}
+
+		// The executable itself may be placed at the path of its shim.
+		if workspaceDir.Join(target).Equal(shimPath) {
+			continue
+		}
+
+		isShim, err := isShimFile(shimPath)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Lstat(shimPath.LocalString()); err == nil && !isShim {
+			return fmt.Errorf("cannot create shim %v for executable %v, as a file not created by lip exists there",
+				shimPath.LocalString(), name)
+		}
+	}
+
+	return nil
+}
+
+// createShims creates the shims of the executables of the tooth in the bin
+// directory. An executable placed right in the bin directory needs no shim.
+func createShims(ctx *context.Context, metadata tooth.Metadata) error {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "install",
+		"method":  "createShims",
+	})
+
+	bin, err := metadata.Bin()
+	if err != nil {
+		return fmt.Errorf("failed to get executables\n\t%w", err)
+	}
+
+	if len(bin) == 0 {
+		return nil
+	}
+
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
+	}
+
+	binDir, err := ctx.BinDir()
+	if err != nil {
+		return fmt.Errorf("failed to get bin directory\n\t%w", err)
+	}
+
+	if err := os.MkdirAll(binDir.LocalString(), 0755); err != nil {
+		return fmt.Errorf("failed to create bin directory\n\t%w", err)
+	}
+
+	names := make([]string, 0, len(bin))
+	for name := range bin {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target := workspaceDir.Join(bin[name])
+		shimPath, err := getShimPath(binDir, name)
+		if err != nil {
+			return err
+		}
+
+		// Executables in archives may have lost their mode bits.
+		if runtime.GOOS != "windows" {
+			if fileInfo, err := os.Stat(target.LocalString()); err == nil && fileInfo.Mode().IsRegular() {
+				if err := os.Chmod(target.LocalString(), fileInfo.Mode()|0111); err != nil {
+					return fmt.Errorf("failed to make %v executable\n\t%w", target.LocalString(), err)
+				}
+			}
+		}
+
+		if target.Equal(shimPath) {
+			debugLogger.Debugf("Executable %v is in the bin directory, no shim needed", name)
+			continue
+		}
+
+		// The target is relative to the shim, so that the workspace can be moved.
+		relTargetStr, err := filepath.Rel(binDir.LocalString(), target.LocalString())
+		if err != nil {
+			return fmt.Errorf("failed to get path of executable %v relative to the bin directory\n\t%w", name, err)
+		}
+
+		if err := os.WriteFile(shimPath.LocalString(), []byte(makeShimContent(metadata.ToothRepoPath(),
+			relTargetStr)), 0755); err != nil {
+			return fmt.Errorf("failed to write shim %v\n\t%w", shimPath.LocalString(), err)
+		}
+
+		debugLogger.Debugf("Created shim %v for %v", shimPath.LocalString(), target.LocalString())
+	}
+
+	return nil
+}
+
+// removeShims removes the shims of the executables of the tooth. Files not
+// created by lip are kept.
+func removeShims(ctx *context.Context, metadata tooth.Metadata) error {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "install",
+		"method":  "removeShims",
+	})
+
+	bin, err := metadata.Bin()
+	if err != nil {
+		return fmt.Errorf("failed to get executables\n\t%w", err)
+	}
+
+	binDir, err := ctx.BinDir()
+	if err != nil {
+		return fmt.Errorf("failed to get bin directory\n\t%w", err)
+	}
+
+	for name := range bin {
+		shimPath, err := getShimPath(binDir, name)
+		if err != nil {
+			return err
+		}
+
+		isShim, err := isShimFile(shimPath)
+		if err != nil {
+			return err
+		}
+
+		if !isShim {
+			continue
+		}
+
+		if err := os.Remove(shimPath.LocalString()); err != nil {
+			return fmt.Errorf("failed to delete shim %v\n\t%w", shimPath.LocalString(), err)
+		}
+
+		debugLogger.Debugf("Deleted shim %v", shimPath.LocalString())
+	}
+
+	return nil
+}
+
+// getShimPath returns the path of the shim of an executable. Shims are batch files
+// on Windows and shell scripts elsewhere. The name must be a single path item.
+func getShimPath(binDir path.Path, name string) (path.Path, error) {
+	fileName := name
+	if runtime.GOOS == "windows" {
+		fileName += ".cmd"
+	}
+
+	if strings.ContainsAny(fileName, `/\`) || fileName == "." || fileName == ".." {
+		return path.Path{}, fmt.Errorf("invalid executable name %v", name)
+	}
+
+	shimFileName, err := path.Parse(fileName)
+	if err != nil {
+		return path.Path{}, fmt.Errorf("invalid executable name %v\n\t%w", name, err)
+	}
+
+	return binDir.Join(shimFileName), nil
+}
+
+// isShimFile checks if the file at shimPath is a shim created by lip. It returns
+// false if the file does not exist.
+func isShimFile(shimPath path.Path) (bool, error) {
+	fileInfo, err := os.Lstat(shimPath.LocalString())
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check shim %v\n\t%w", shimPath.LocalString(), err)
+	}
+
+	if !fileInfo.Mode().IsRegular() {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(shimPath.LocalString())
+	if err != nil {
+		return false, fmt.Errorf("failed to read shim %v\n\t%w", shimPath.LocalString(), err)
+	}
+
+	return strings.Contains(string(content), shimMarker), nil
+}
+
+// makeShimContent makes a shim running the executable at relTargetStr, relative
+// to the directory of the shim, with the arguments of the shim.
+func makeShimContent(toothRepoPath string, relTargetStr string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf("@echo off\r\nrem %v%v. Do not edit.\r\n\"%%~dp0%v\" %%*\r\n",
+			shimMarker, toothRepoPath, filepath.FromSlash(relTargetStr))
+	}
+
+	// Characters special in double quotes are escaped.
+	escapedRelTargetStr := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(
+		filepath.ToSlash(relTargetStr))
+
+	return fmt.Sprintf("#!/bin/sh\n# %v%v. Do not edit.\nexec \"$(dirname \"$0\")/%v\" \"$@\"\n",
+		shimMarker, toothRepoPath, escapedRelTargetStr)
+}
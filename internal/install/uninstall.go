@@ -2,119 +2,135 @@ package install
 
 import (
 	"fmt"
-	"net/url"
 	"os"
 
 	"github.com/lippkg/lip/internal/context"
-	"github.com/lippkg/lip/internal/path"
 	"github.com/lippkg/lip/internal/tooth"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func Uninstall(ctx *context.Context, toothRepoPath string) error {
+// Uninstall uninstalls a tooth and removes it from the installed store. If
+// allowScripts is true, commands declared by the tooth are run without asking
+// under the prompt script policy. If ignoreScripts is true, they will not be run.
+func Uninstall(ctx *context.Context, installedStore *tooth.InstalledStore, toothRepoPath string, allowScripts bool,
+	ignoreScripts bool) error {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "install",
 		"method":  "Uninstall",
 	})
 
-	commandEnvirons := make(map[string]string)
+	installedTooth, ok := installedStore.Lookup(toothRepoPath)
+	if !ok {
+		return fmt.Errorf("tooth %v is not installed", toothRepoPath)
+	}
+
+	metadata := installedTooth.Metadata
+
+	// 1. Review commands and run pre-uninstall commands.
 
-	proxyURL, err := ctx.ProxyURL()
+	commands := metadata.Commands()
+	shouldRunCommands, err := isCommandRunAllowed(ctx, toothRepoPath, map[string][]tooth.CommandsItem{
+		"pre_uninstall":  commands.PreUninstall,
+		"post_uninstall": commands.PostUninstall,
+	}, []string{"pre_uninstall", "post_uninstall"}, allowScripts, ignoreScripts)
 	if err != nil {
-		return fmt.Errorf("failed to get proxy URL\n\t%w", err)
+		return fmt.Errorf("failed to review commands\n\t%w", err)
 	}
 
-	if proxyURL.String() != "" {
-		commandEnvirons = map[string]string{
-			"HTTP_PROXY":  proxyURL.String(),
-			"HTTPS_PROXY": proxyURL.String(),
-		}
+	if _, err := runCommands(ctx, commands.PreUninstall, hookEnvironment{
+		toothRepoPath: toothRepoPath,
+		version:       metadata.Version(),
+		phase:         "pre_uninstall",
+	}, shouldRunCommands); err != nil {
+		return fmt.Errorf("failed to run pre-uninstall commands\n\t%w", err)
 	}
+	debugLogger.Debug("Ran pre-uninstall commands")
 
-	metadata, err := tooth.GetMetadata(ctx, toothRepoPath)
-	if err != nil {
-		return err
+	// 2. Remove shims, revert effects of actions run on install and delete files.
+
+	if err := removeShims(ctx, metadata); err != nil {
+		return fmt.Errorf("failed to remove shims\n\t%w", err)
 	}
+	debugLogger.Debug("Removed shims")
 
-	// 1. Run pre-uninstall commands.
+	effects, err := loadEffects(ctx, toothRepoPath)
+	if err != nil {
+		return fmt.Errorf("failed to load effects of actions\n\t%w", err)
+	}
 
-	if err := runCommands(metadata.Commands().PreUninstall, commandEnvirons); err != nil {
-		return fmt.Errorf("failed to run pre-uninstall commands\n\t%w", err)
+	workspaceDir, err := ctx.WorkspaceDir()
+	if err != nil {
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
 	}
-	debugLogger.Debug("Ran pre-uninstall commands")
 
-	// 2. Delete files.
+	revertEffects(workspaceDir, effects)
+	debugLogger.Debug("Reverted effects of actions")
 
-	if err := removeToothFiles(ctx, metadata); err != nil {
+	if err := removeToothFiles(ctx, installedTooth); err != nil {
 		return fmt.Errorf("failed to delete files\n\t%w", err)
 	}
 	debugLogger.Debug("Deleted files")
 
 	// 3. Run post-uninstall commands.
 
-	if err := runCommands(metadata.Commands().PostUninstall, commandEnvirons); err != nil {
+	if _, err := runCommands(ctx, commands.PostUninstall, hookEnvironment{
+		toothRepoPath: toothRepoPath,
+		version:       metadata.Version(),
+		phase:         "post_uninstall",
+	}, shouldRunCommands); err != nil {
 		return fmt.Errorf("failed to run post-uninstall commands\n\t%w", err)
 	}
 	debugLogger.Debug("Ran post-uninstall commands")
 
-	// 4. Delete the metadata file.
+	// 4. Delete the recorded effects and the installed tooth record.
 
-	metadataDir, err := ctx.MetadataDir()
-	if err != nil {
-		return fmt.Errorf("failed to get metadata directory\n\t%w", err)
+	if err := removeEffects(ctx, toothRepoPath); err != nil {
+		return fmt.Errorf("failed to remove effects of actions\n\t%w", err)
 	}
 
-	metadataFileName := fmt.Sprintf("%v.json", url.QueryEscape(toothRepoPath))
-	metadataPath := metadataDir.Join(path.MustParse(metadataFileName))
-
-	if err := os.Remove(metadataPath.LocalString()); err != nil {
-		return fmt.Errorf("failed to delete metadata file\n\t%w", err)
+	if err := installedStore.Remove(toothRepoPath); err != nil {
+		return fmt.Errorf("failed to remove installed tooth record\n\t%w", err)
 	}
 
-	debugLogger.Debugf("Deleted metadata file %v", metadataPath.LocalString())
+	debugLogger.Debugf("Removed tooth %v from installed teeth", toothRepoPath)
 
 	return nil
 }
 
-// removeToothFiles removes the files of the tooth.
-func removeToothFiles(ctx *context.Context, metadata tooth.Metadata) error {
+// removeToothFiles removes the files placed by the tooth.
+func removeToothFiles(ctx *context.Context, installedTooth tooth.InstalledTooth) error {
 	debugLogger := log.WithFields(log.Fields{
 		"package": "install",
 		"method":  "removeToothFiles",
 	})
 
-	workspaceDirStr, err := os.Getwd()
+	workspaceDir, err := ctx.WorkspaceDir()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get workspace directory\n\t%w", err)
 	}
 
-	workspaceDir, err := path.Parse(workspaceDirStr)
-	if err != nil {
-		return fmt.Errorf("failed to parse workspace directory\n\t%w", err)
-	}
+	metadata := installedTooth.Metadata
 
 	files, err := metadata.Files()
 	if err != nil {
 		return fmt.Errorf("failed to get files from metadata\n\t%w", err)
 	}
 
-	for _, place := range files.Place {
+	for _, relDest := range installedTooth.Files {
 		// Files marked as "preserve" will not be deleted.
 		isPreserved := false
 		for _, preserve := range files.Preserve {
-			if place.Dest.Equal(preserve) {
+			if relDest.Equal(preserve) {
 				isPreserved = true
 				break
 			}
 		}
 		if isPreserved {
-			debugLogger.Debugf("Preserved file %v", place.Dest)
+			debugLogger.Debugf("Preserved file %v", relDest)
 			continue
 		}
 
-		relDest := place.Dest
-
 		dest := workspaceDir.Join(relDest)
 
 		// Delete the file.
@@ -170,12 +186,16 @@ func removeToothFiles(ctx *context.Context, metadata tooth.Metadata) error {
 	}
 
 	for _, removal := range files.Remove {
-		removalPath := removal
+		removalPath, err := resolveWorkspacePath(workspaceDir, removal.String())
+		if err != nil {
+			log.Warnf("Skipping removal of %v\n\t%v", removal.LocalString(), err)
+			continue
+		}
 
-		if err := os.RemoveAll(workspaceDir.Join(removalPath).LocalString()); err != nil {
+		if err := os.RemoveAll(removalPath.LocalString()); err != nil {
 			return fmt.Errorf("failed to delete file\n\t%w", err)
 		}
-		debugLogger.Debugf("Deleted file %v that is marked as \"remove\"", workspaceDir.Join(removalPath).LocalString())
+		debugLogger.Debugf("Deleted file %v that is marked as \"remove\"", removalPath.LocalString())
 	}
 
 	return nil
@@ -0,0 +1,100 @@
+package lock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// Lock is an advisory exclusive lock on a file, held across processes. The lock
+// is released automatically if the holding process exits.
+type Lock struct {
+	file *os.File
+}
+
+// Acquire acquires the lock on the file at lockPath, creating the file if needed.
+// If another process holds the lock, it waits until the lock is released or the
+// timeout expires. A zero timeout means waiting forever.
+func Acquire(lockPath path.Path, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(lockPath.LocalString()), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of lock file %v\n\t%w", lockPath.LocalString(), err)
+	}
+
+	file, err := os.OpenFile(lockPath.LocalString(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %v\n\t%w", lockPath.LocalString(), err)
+	}
+
+	startTime := time.Now()
+	isWaiting := false
+
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %v\n\t%w", lockPath.LocalString(), err)
+		}
+
+		if locked {
+			break
+		}
+
+		holder := readHolder(lockPath)
+
+		if timeout > 0 && time.Since(startTime) >= timeout {
+			file.Close()
+			return nil, fmt.Errorf("timed out after %v waiting for lock %v held by PID %v", timeout,
+				lockPath.LocalString(), holder)
+		}
+
+		if !isWaiting {
+			log.Infof("Waiting for lock %v held by PID %v", lockPath.LocalString(), holder)
+			isWaiting = true
+		}
+
+		time.Sleep(pollInterval)
+	}
+
+	// Record the PID of the holder for processes waiting for the lock.
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	file := l.file
+	l.file = nil
+
+	if err := unlock(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to unlock %v\n\t%w", file.Name(), err)
+	}
+
+	return file.Close()
+}
+
+// IsHeld checks if the lock has not been released.
+func (l *Lock) IsHeld() bool {
+	return l.file != nil
+}
+
+// readHolder returns the PID recorded in a lock file, or "unknown".
+func readHolder(lockPath path.Path) string {
+	content, err := os.ReadFile(lockPath.LocalString())
+	if err != nil || strings.TrimSpace(string(content)) == "" {
+		return "unknown"
+	}
+
+	return strings.TrimSpace(string(content))
+}
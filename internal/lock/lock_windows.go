@@ -0,0 +1,37 @@
+//go:build windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// The locked byte range lies beyond the recorded PID so that other processes can
+// still read the PID while the lock is held.
+const (
+	lockOffset = 0xffffffff
+	lockLength = 1
+)
+
+func tryLock(file *os.File) (bool, error) {
+	overlapped := windows.Overlapped{Offset: lockOffset}
+
+	err := windows.LockFileEx(windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, lockLength, 0, &overlapped)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func unlock(file *os.File) error {
+	overlapped := windows.Overlapped{Offset: lockOffset}
+
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockLength, 0, &overlapped)
+}
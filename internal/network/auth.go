@@ -0,0 +1,184 @@
+package network
+
+import (
+	"bytes"
+	gocontext "context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostAuth is the credentials of a host. Headers are always sent. Of the others,
+// a credential helper takes precedence over a token, which takes precedence over
+// basic auth.
+type HostAuth struct {
+	// Token is sent as a bearer token.
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Headers are sent as they are, e.g. for API keys.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Helper is a shell command printing a bearer token to stdout. The host is
+	// passed in the LIP_AUTH_HOST environment variable.
+	Helper string `json:"helper,omitempty"`
+}
+
+const credentialHelperTimeout = 30 * time.Second
+
+// Tokens printed by credential helpers, keyed by helper and host, so that each
+// helper runs at most once per host in a process.
+var helperTokens sync.Map
+
+// authTransport adds the credentials of the host of each request. As they are
+// added per request, credentials are never forwarded to another host on redirect.
+type authTransport struct {
+	base    http.RoundTripper
+	options Options
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, err := getAuthHeader(req.URL, t.options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	authReq := req.Clone(req.Context())
+	for key, values := range header {
+		authReq.Header[key] = values
+	}
+
+	return t.base.RoundTrip(authReq)
+}
+
+// ---------------------------------------------------------------------
+
+// getAuthHeader returns the headers carrying the credentials of the host of u.
+// Credentials in config take precedence over those in the netrc file.
+func getAuthHeader(u *url.URL, options Options) (http.Header, error) {
+	header := make(http.Header)
+
+	if hostAuth, ok := lookupHostAuth(u, options.Auth); ok {
+		for key, value := range hostAuth.Headers {
+			header.Set(key, value)
+		}
+
+		switch {
+		case hostAuth.Helper != "":
+			token, err := runCredentialHelper(hostAuth.Helper, u.Host)
+			if err != nil {
+				return nil, err
+			}
+			header.Set("Authorization", "Bearer "+token)
+
+		case hostAuth.Token != "":
+			header.Set("Authorization", "Bearer "+hostAuth.Token)
+
+		case hostAuth.Username != "" || hostAuth.Password != "":
+			header.Set("Authorization", basicAuth(hostAuth.Username, hostAuth.Password))
+		}
+
+		return header, nil
+	}
+
+	login, password, ok, err := lookupNetrc(options.NetrcPath, u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		header.Set("Authorization", basicAuth(login, password))
+	}
+
+	return header, nil
+}
+
+// getProxyUserinfo returns the credentials of the proxy. User info in the proxy
+// URL takes precedence over basic auth of the proxy host in config and the netrc
+// file.
+func getProxyUserinfo(proxyURL *url.URL, options Options) (*url.Userinfo, error) {
+	if proxyURL.User != nil {
+		return proxyURL.User, nil
+	}
+
+	if hostAuth, ok := lookupHostAuth(proxyURL, options.Auth); ok {
+		if hostAuth.Username != "" || hostAuth.Password != "" {
+			return url.UserPassword(hostAuth.Username, hostAuth.Password), nil
+		}
+
+		return nil, nil
+	}
+
+	login, password, ok, err := lookupNetrc(options.NetrcPath, proxyURL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		return url.UserPassword(login, password), nil
+	}
+
+	return nil, nil
+}
+
+func basicAuth(username string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// lookupHostAuth returns the credentials of the host of u, matching the host with
+// port first and then the host name alone.
+func lookupHostAuth(u *url.URL, auth map[string]HostAuth) (HostAuth, bool) {
+	if hostAuth, ok := auth[u.Host]; ok {
+		return hostAuth, true
+	}
+
+	hostAuth, ok := auth[u.Hostname()]
+	return hostAuth, ok
+}
+
+// runCredentialHelper runs a credential helper and returns the token it prints.
+// Output of the helper is never included in errors as it may contain secrets.
+func runCredentialHelper(helper string, host string) (string, error) {
+	cacheKey := helper + "\x00" + host
+	if token, ok := helperTokens.Load(cacheKey); ok {
+		return token.(string), nil
+	}
+
+	cmdCtx, cancel := gocontext.WithTimeout(gocontext.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(cmdCtx, "cmd", "/C", helper)
+	} else {
+		cmd = exec.CommandContext(cmdCtx, "sh", "-c", helper)
+	}
+
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("LIP_AUTH_HOST=%v", host))
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("credential helper for %v failed\n\t%w", host, err)
+	}
+
+	token := strings.TrimSpace(strings.SplitN(stdout.String(), "\n", 2)[0])
+	if token == "" {
+		return "", fmt.Errorf("credential helper for %v printed no token", host)
+	}
+
+	helperTokens.Store(cacheKey, token)
+
+	return token, nil
+}
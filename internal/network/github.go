@@ -1,26 +1,15 @@
 package network
 
 import (
-	"fmt"
-	gourl "net/url"
+	"net/url"
+	"strings"
 )
 
-// GenerateGitHubMirrorURL generates a GitHub mirror URL from a GitHub URL.
-func GenerateGitHubMirrorURL(url *gourl.URL, gitHubMirrorURL *gourl.URL) (*gourl.URL, error) {
-	if !IsGitHubDirectDownloadURL(url) {
-		return nil, fmt.Errorf("not a GitHub URL: %v", url)
+// GitHubMirrorRule returns the rewrite rule redirecting GitHub URLs to the GitHub
+// mirror.
+func GitHubMirrorRule(gitHubMirrorURL *url.URL) RewriteRule {
+	return RewriteRule{
+		Regex:       `^https?://github\.com/`,
+		Replacement: strings.TrimSuffix(gitHubMirrorURL.String(), "/") + "/",
 	}
-
-	// Replace the host of the URL with the GitHub mirror URL.
-	mirroredURL, err := url.Parse(fmt.Sprintf("%v%v", gitHubMirrorURL, url.Path))
-	if err != nil {
-		return nil, fmt.Errorf("cannot parse GitHub mirror URL\n\t%w", err)
-	}
-
-	return mirroredURL, nil
-}
-
-// IsGitHubDirectDownloadURL checks if a URL is a GitHub URL that can be directly downloaded.
-func IsGitHubDirectDownloadURL(url *gourl.URL) bool {
-	return url.Host == "github.com" && (url.Scheme == "http" || url.Scheme == "https")
 }
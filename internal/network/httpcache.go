@@ -0,0 +1,156 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Contents got in this process, keyed by URL.
+var contentMemo sync.Map
+
+// responseCacheEntry is a cached response of GetContent.
+type responseCacheEntry struct {
+	URL string `json:"url"`
+	// Source is the URL that served the response after rewriting. The validators
+	// are only sent to it, as mirrors may assign different ETags to the content.
+	Source       string    `json:"source,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	CacheControl string    `json:"cache_control,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	Body         []byte    `json:"body"`
+}
+
+// isFresh reports whether the entry may be used without revalidation. A zero TTL
+// always requires revalidation. Otherwise, the max-age of Cache-Control takes
+// precedence over the TTL, and no-cache requires revalidation.
+func (entry responseCacheEntry) isFresh(ttl time.Duration) bool {
+	if ttl == 0 {
+		return false
+	}
+
+	freshness := ttl
+
+	for _, directive := range parseCacheControl(entry.CacheControl) {
+		name, value, _ := strings.Cut(directive, "=")
+
+		switch name {
+		case "no-cache":
+			return false
+
+		case "max-age":
+			seconds, err := strconv.Atoi(value)
+			if err == nil && seconds >= 0 {
+				freshness = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return time.Since(entry.FetchedAt) < freshness
+}
+
+// setValidators sets the conditional headers validating the entry on a request.
+func (entry responseCacheEntry) setValidators(req *http.Request) {
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// getResponseCacheFilePath returns the path of the cached response of a URL.
+func getResponseCacheFilePath(cacheDir string, urlStr string) string {
+	hash := sha256.Sum256([]byte(urlStr))
+	return filepath.Join(cacheDir, hex.EncodeToString(hash[:])+".json")
+}
+
+// loadResponseCacheEntry loads the cached response of a URL. A missing or
+// unreadable entry is treated as not cached.
+func loadResponseCacheEntry(cacheDir string, urlStr string) (responseCacheEntry, bool) {
+	if cacheDir == "" {
+		return responseCacheEntry{}, false
+	}
+
+	jsonBytes, err := os.ReadFile(getResponseCacheFilePath(cacheDir, urlStr))
+	if err != nil {
+		return responseCacheEntry{}, false
+	}
+
+	var entry responseCacheEntry
+	if err := json.Unmarshal(jsonBytes, &entry); err != nil || entry.URL != urlStr {
+		return responseCacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+// saveResponseCacheEntry saves a response unless Cache-Control forbids storing it.
+func saveResponseCacheEntry(cacheDir string, entry responseCacheEntry) error {
+	if cacheDir == "" {
+		return nil
+	}
+
+	for _, directive := range parseCacheControl(entry.CacheControl) {
+		if directive == "no-store" {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return fmt.Errorf("cannot create response cache directory\n\t%w", err)
+	}
+
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot marshal cached response\n\t%w", err)
+	}
+
+	// Write to a temporary file first so that concurrent readers never see a
+	// partial entry.
+	file, err := os.CreateTemp(cacheDir, "response-*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create cached response\n\t%w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := file.Write(jsonBytes); err != nil {
+		return fmt.Errorf("cannot write cached response\n\t%w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("cannot write cached response\n\t%w", err)
+	}
+
+	if err := os.Rename(file.Name(), getResponseCacheFilePath(cacheDir, entry.URL)); err != nil {
+		return fmt.Errorf("cannot move cached response\n\t%w", err)
+	}
+
+	return nil
+}
+
+// parseCacheControl splits a Cache-Control header into lowercase directives.
+func parseCacheControl(cacheControl string) []string {
+	directives := make([]string, 0)
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+
+	return directives
+}
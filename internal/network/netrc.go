@@ -0,0 +1,117 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type netrcEntry struct {
+	machine  string // Empty for the default entry.
+	login    string
+	password string
+}
+
+// lookupNetrc returns the login and password of the machine in the netrc file at
+// netrcPath, falling back to the default entry. A missing file has no entries.
+func lookupNetrc(netrcPath string, machine string) (string, string, bool, error) {
+	if netrcPath == "" {
+		return "", "", false, nil
+	}
+
+	content, err := os.ReadFile(netrcPath)
+	if os.IsNotExist(err) {
+		return "", "", false, nil
+	} else if err != nil {
+		return "", "", false, fmt.Errorf("cannot read netrc file %v\n\t%w", netrcPath, err)
+	}
+
+	entries, err := parseNetrc(string(content))
+	if err != nil {
+		return "", "", false, fmt.Errorf("cannot parse netrc file %v\n\t%w", netrcPath, err)
+	}
+
+	for _, entry := range entries {
+		if entry.machine == machine {
+			return entry.login, entry.password, true, nil
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.machine == "" {
+			return entry.login, entry.password, true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
+
+// parseNetrc parses the content of a netrc file. Macro definitions are skipped.
+func parseNetrc(content string) ([]netrcEntry, error) {
+	entries := make([]netrcEntry, 0)
+
+	var current *netrcEntry
+	inMacro := false
+
+	for lineIndex, line := range strings.Split(content, "\n") {
+		// A macro definition ends at an empty line.
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			switch token {
+			case "machine", "login", "password", "account", "macdef":
+				if i+1 >= len(fields) {
+					return nil, fmt.Errorf("missing value of %v at line %v", token, lineIndex+1)
+				}
+			}
+
+			switch token {
+			case "machine":
+				i++
+				entries = append(entries, netrcEntry{machine: fields[i]})
+				current = &entries[len(entries)-1]
+
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+
+			case "login", "password":
+				if current == nil {
+					return nil, fmt.Errorf("%v outside of a machine entry at line %v", token, lineIndex+1)
+				}
+
+				i++
+				if token == "login" {
+					current.login = fields[i]
+				} else {
+					current.password = fields[i]
+				}
+
+			case "account":
+				i++
+
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+
+			default:
+				// The token is not shown as it may be a secret.
+				return nil, fmt.Errorf("unexpected token at line %v", lineIndex+1)
+			}
+		}
+	}
+
+	return entries, nil
+}
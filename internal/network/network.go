@@ -6,31 +6,182 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/lippkg/lip/internal/path"
 	"github.com/schollz/progressbar/v3"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// Options configures HTTP requests.
+type Options struct {
+	// ProxyURL is the URL of the proxy for all hosts, or empty to use the proxy
+	// environment variables.
+	ProxyURL *url.URL
+	// NoProxy are hosts, domains, IP addresses or CIDR ranges never proxied, in
+	// addition to those in NO_PROXY.
+	NoProxy []string
+	// Auth maps hosts, optionally with ports, to their credentials.
+	Auth map[string]HostAuth
+	// NetrcPath is the path of the netrc file, or empty for none.
+	NetrcPath string
+	// RewriteRules are applied in order to the URL of every request. Only the first
+	// matching rule takes effect.
+	RewriteRules []RewriteRule
+	// CABundlePath is the path of a PEM file of CA certificates trusted in addition
+	// to the system ones, or empty for none.
+	CABundlePath string
+	// ClientCertPath and ClientKeyPath are the paths of PEM files of the client
+	// certificate and its key for mutual TLS, or empty for none.
+	ClientCertPath string
+	ClientKeyPath  string
+	// InsecureSkipVerify disables verification of TLS certificates of servers.
+	InsecureSkipVerify bool
+	// UserAgent is sent in every request, or Go's default if empty.
+	UserAgent string
+	// ResponseCacheDir is the directory caching responses of GetContent, or empty
+	// to cache them only in memory.
+	ResponseCacheDir string
+	// ResponseCacheTTL is how long a cached response is used without revalidation,
+	// unless the server sets max-age in Cache-Control.
+	ResponseCacheTTL time.Duration
+}
+
+// DownloadedFile describes a downloaded file.
+type DownloadedFile struct {
+	// ETag is the entity tag of the response, or empty if the server did not send one.
+	ETag string
+	// URL is the URL the file was downloaded from after rewriting.
+	URL *url.URL
+}
+
 // DownloadFile downloads a file from a url and saves it to a local path.
-func DownloadFile(url *url.URL, proxyURL *url.URL, filePath path.Path, enableProgressBar bool) error {
-	httpClient := getProxiedHTTPClient(proxyURL)
+func DownloadFile(url *url.URL, options Options, filePath path.Path, enableProgressBar bool) (DownloadedFile, error) {
+	httpClient, err := newHTTPClient(options)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+
+	debugLogger := log.WithFields(log.Fields{
+		"package": "network",
+		"method":  "DownloadFile",
+	})
+
+	candidateURLs, err := getCandidateURLs(url, options.RewriteRules)
+	if err != nil {
+		return DownloadedFile{}, err
+	}
+
+	var downloadedFile DownloadedFile
+	for i, candidateURL := range candidateURLs {
+		if candidateURL != url {
+			debugLogger.Debugf("Rewrote %v to %v", url, candidateURL)
+		}
+
+		downloadedFile, err = downloadFileFrom(httpClient, candidateURL, filePath, enableProgressBar)
+		if err == nil || i == len(candidateURLs)-1 {
+			break
+		}
+
+		log.Warnf("Failed to download from %v, falling back to %v\n\t%v", candidateURL, url, err)
+	}
+
+	return downloadedFile, err
+}
+
+// GetContent gets the content at once of a URL. Contents are cached in memory for
+// the process and, if a cache directory is set, on disk, where stale responses are
+// revalidated with ETag and Last-Modified.
+func GetContent(url *url.URL, options Options) ([]byte, error) {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "network",
+		"method":  "GetContent",
+	})
+
+	if content, ok := contentMemo.Load(url.String()); ok {
+		return content.([]byte), nil
+	}
+
+	cachedEntry, cached := loadResponseCacheEntry(options.ResponseCacheDir, url.String())
+	if cached && cachedEntry.isFresh(options.ResponseCacheTTL) {
+		debugLogger.Debugf("Using cached response of %v", url)
+		contentMemo.Store(url.String(), cachedEntry.Body)
+		return cachedEntry.Body, nil
+	}
+
+	httpClient, err := newHTTPClient(options)
+	if err != nil {
+		return nil, err
+	}
+
+	candidateURLs, err := getCandidateURLs(url, options.RewriteRules)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry responseCacheEntry
+	for i, candidateURL := range candidateURLs {
+		if candidateURL != url {
+			debugLogger.Debugf("Rewrote %v to %v", url, candidateURL)
+		}
+
+		var cachedEntryPtr *responseCacheEntry
+		if cached && cachedEntry.Source == candidateURL.String() {
+			cachedEntryPtr = &cachedEntry
+		}
+
+		entry, err = getContentFrom(httpClient, candidateURL, cachedEntryPtr)
+		if err == nil {
+			entry.Source = candidateURL.String()
+			break
+		}
+
+		if i == len(candidateURLs)-1 {
+			break
+		}
+
+		log.Warnf("Failed to get content from %v, falling back to %v\n\t%v", candidateURL, url, err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Cached by the original URL, as rewriting may change between runs.
+	entry.URL = url.String()
+	if err := saveResponseCacheEntry(options.ResponseCacheDir, entry); err != nil {
+		log.Warnf("Failed to cache response of %v\n\t%v", url, err)
+	}
+
+	contentMemo.Store(url.String(), entry.Body)
+
+	return entry.Body, nil
+}
+
+// ---------------------------------------------------------------------
+
+func downloadFileFrom(httpClient *http.Client, url *url.URL, filePath path.Path,
+	enableProgressBar bool) (DownloadedFile, error) {
 
 	resp, err := httpClient.Get(url.String())
 	if err != nil {
-		return fmt.Errorf("cannot send HTTP request\n\t%w", err)
+		return DownloadedFile{}, fmt.Errorf("cannot send HTTP request\n\t%w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("cannot download file (HTTP %v): %v", resp.Status, url)
+		return DownloadedFile{}, fmt.Errorf("cannot download file (HTTP %v): %v", resp.Status, url)
 	}
 
-	// Create the file
-	file, err := os.Create(filePath.LocalString() + ".tmp")
+	// Download to a unique temporary file so that concurrent downloads to the same
+	// path do not interfere, and move it to the path only on success.
+	file, err := os.CreateTemp(filepath.Dir(filePath.LocalString()), filepath.Base(filePath.LocalString())+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("cannot create file\n\t%w", err)
+		return DownloadedFile{}, fmt.Errorf("cannot create file\n\t%w", err)
 	}
-	defer os.Rename(filePath.LocalString() + ".tmp", filePath.LocalString())
+	defer os.Remove(file.Name())
 	defer file.Close()
 
 	var writer io.Writer = file
@@ -46,39 +197,91 @@ func DownloadFile(url *url.URL, proxyURL *url.URL, filePath path.Path, enablePro
 	}
 
 	if _, err := io.Copy(writer, resp.Body); err != nil {
-		return fmt.Errorf("cannot download file from %v\n\t%w", url, err)
+		return DownloadedFile{}, fmt.Errorf("cannot download file from %v\n\t%w", url, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return DownloadedFile{}, fmt.Errorf("cannot write file\n\t%w", err)
 	}
-	return nil
+
+	if err := os.Rename(file.Name(), filePath.LocalString()); err != nil {
+		return DownloadedFile{}, fmt.Errorf("cannot move downloaded file to %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	return DownloadedFile{
+		ETag: resp.Header.Get("ETag"),
+		URL:  url,
+	}, nil
 }
 
-// GetContent gets the content at once of a URL.
-func GetContent(url *url.URL, proxyURL *url.URL) ([]byte, error) {
-	httpClient := getProxiedHTTPClient(proxyURL)
+// getContentFrom gets the content of a URL. If a cached entry is given, the request
+// is conditional and the cached body is reused when the server replies 304.
+func getContentFrom(httpClient *http.Client, url *url.URL, cachedEntry *responseCacheEntry) (responseCacheEntry, error) {
+	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	if err != nil {
+		return responseCacheEntry{}, fmt.Errorf("cannot create HTTP request\n\t%w", err)
+	}
+
+	if cachedEntry != nil {
+		cachedEntry.setValidators(req)
+	}
 
-	resp, err := httpClient.Get(url.String())
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("cannot send HTTP request\n\t%w", err)
+		return responseCacheEntry{}, fmt.Errorf("cannot send HTTP request\n\t%w", err)
 	}
 	defer resp.Body.Close()
 
+	entry := responseCacheEntry{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		CacheControl: resp.Header.Get("Cache-Control"),
+		FetchedAt:    time.Now(),
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cachedEntry != nil {
+		entry.Body = cachedEntry.Body
+		if entry.ETag == "" {
+			entry.ETag = cachedEntry.ETag
+		}
+		if entry.LastModified == "" {
+			entry.LastModified = cachedEntry.LastModified
+		}
+
+		return entry, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("cannot get content (HTTP %v): %v", resp.Status, url)
+		return responseCacheEntry{}, fmt.Errorf("cannot get content (HTTP %v): %v", resp.Status, url)
 	}
 
-	content, err := io.ReadAll(resp.Body)
+	entry.Body, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read HTTP response\n\t%w", err)
+		return responseCacheEntry{}, fmt.Errorf("cannot read HTTP response\n\t%w", err)
 	}
 
-	return content, nil
+	return entry, nil
 }
 
-func getProxiedHTTPClient(proxyURL *url.URL) *http.Client {
-	if proxyURL.String() == "" {
-		return http.DefaultClient
+// newHTTPClient creates an HTTP client sending requests through the proxy chosen
+// for each host, with the credentials of each host and the TLS settings.
+func newHTTPClient(options Options) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		return nil, err
 	}
 
 	transport := http.DefaultTransport.(*http.Transport).Clone()
-	transport.Proxy = http.ProxyURL(proxyURL)
-	return &http.Client{Transport: transport}
+	transport.Proxy = getProxyFunc(options)
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: &userAgentTransport{
+			base: &authTransport{
+				base:    transport,
+				options: options,
+			},
+			userAgent: options.UserAgent,
+		},
+	}, nil
 }
@@ -0,0 +1,95 @@
+package network
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxySchemes are the schemes supported in proxy URLs.
+var ProxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// ProxyEnv returns the proxy environment variables for child processes, so that
+// they make the same proxy decision as lip. Proxy credentials from config and the
+// netrc file are not included.
+func ProxyEnv(options Options) []string {
+	config := getProxyConfig(options)
+
+	environs := make([]string, 0)
+	for _, item := range []struct {
+		name  string
+		value string
+	}{
+		{"HTTP_PROXY", config.HTTPProxy},
+		{"HTTPS_PROXY", config.HTTPSProxy},
+		{"NO_PROXY", config.NoProxy},
+	} {
+		if item.value == "" {
+			continue
+		}
+
+		environs = append(environs,
+			fmt.Sprintf("%v=%v", item.name, item.value),
+			fmt.Sprintf("%v=%v", strings.ToLower(item.name), item.value),
+		)
+	}
+
+	return environs
+}
+
+// ---------------------------------------------------------------------
+
+// getProxyConfig returns the proxy decision of the options. The proxy URL in the
+// options applies to both HTTP and HTTPS. Without it, HTTP_PROXY, HTTPS_PROXY and
+// their lowercase variants are used. Hosts in NO_PROXY and in the options are
+// never proxied.
+func getProxyConfig(options Options) *httpproxy.Config {
+	config := httpproxy.FromEnvironment()
+
+	if options.ProxyURL != nil && options.ProxyURL.String() != "" {
+		config.HTTPProxy = options.ProxyURL.String()
+		config.HTTPSProxy = options.ProxyURL.String()
+	}
+
+	noProxy := make([]string, 0)
+	if config.NoProxy != "" {
+		noProxy = append(noProxy, config.NoProxy)
+	}
+	noProxy = append(noProxy, options.NoProxy...)
+	config.NoProxy = strings.Join(noProxy, ",")
+
+	return config
+}
+
+// getProxyFunc returns the function choosing the proxy of each request, with the
+// proxy credentials from config and the netrc file.
+func getProxyFunc(options Options) func(*http.Request) (*url.URL, error) {
+	config := getProxyConfig(options)
+
+	// Go resolves host names on the SOCKS5 proxy in either case, and httpproxy does
+	// not know socks5h.
+	config.HTTPProxy = strings.Replace(config.HTTPProxy, "socks5h://", "socks5://", 1)
+	config.HTTPSProxy = strings.Replace(config.HTTPSProxy, "socks5h://", "socks5://", 1)
+
+	proxyFunc := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxyFunc(req.URL)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+
+		userinfo, err := getProxyUserinfo(proxyURL, options)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get proxy credentials\n\t%w", err)
+		}
+
+		proxyURLWithUser := *proxyURL
+		proxyURLWithUser.User = userinfo
+
+		return &proxyURLWithUser, nil
+	}
+}
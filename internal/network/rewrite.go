@@ -0,0 +1,89 @@
+package network
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule rewrites URLs matching a prefix or a regular expression. Exactly one
+// of Prefix and Regex is set.
+type RewriteRule struct {
+	// Prefix is replaced by Replacement.
+	Prefix string `json:"prefix,omitempty"`
+	// Regex is replaced by Replacement, which may refer to submatches as $1.
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement"`
+	// Fallback requests the original URL if the request to the rewritten URL fails.
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// Validate checks that the rule is well-formed.
+func (rule RewriteRule) Validate() error {
+	if (rule.Prefix == "") == (rule.Regex == "") {
+		return fmt.Errorf("exactly one of prefix and regex must be set")
+	}
+
+	if rule.Regex != "" {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("invalid regex %v\n\t%w", rule.Regex, err)
+		}
+	}
+
+	if rule.Replacement == "" {
+		return fmt.Errorf("replacement must be set")
+	}
+
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// getCandidateURLs returns the URLs to request for u in order: the URL rewritten by
+// the first matching rule, followed by u itself if the rule allows fallback. It is
+// u alone if no rule matches.
+func getCandidateURLs(u *url.URL, rules []RewriteRule) ([]*url.URL, error) {
+	urlStr := u.String()
+
+	for _, rule := range rules {
+		var rewrittenStr string
+
+		if rule.Prefix != "" {
+			if !strings.HasPrefix(urlStr, rule.Prefix) {
+				continue
+			}
+
+			rewrittenStr = rule.Replacement + strings.TrimPrefix(urlStr, rule.Prefix)
+
+		} else {
+			regex, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %v in rewrite rule\n\t%w", rule.Regex, err)
+			}
+
+			if !regex.MatchString(urlStr) {
+				continue
+			}
+
+			rewrittenStr = regex.ReplaceAllString(urlStr, rule.Replacement)
+		}
+
+		rewrittenURL, err := url.Parse(rewrittenStr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse rewritten URL %v\n\t%w", rewrittenStr, err)
+		}
+
+		if rewrittenURL.String() == urlStr {
+			return []*url.URL{u}, nil
+		}
+
+		if rule.Fallback {
+			return []*url.URL{rewrittenURL, u}, nil
+		}
+
+		return []*url.URL{rewrittenURL}, nil
+	}
+
+	return []*url.URL{u}, nil
+}
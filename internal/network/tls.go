@@ -0,0 +1,79 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var warnInsecureSkipVerifyOnce sync.Once
+
+// userAgentTransport sets the User-Agent header of every request.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.userAgent == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	userAgentReq := req.Clone(req.Context())
+	userAgentReq.Header.Set("User-Agent", t.userAgent)
+
+	return t.base.RoundTrip(userAgentReq)
+}
+
+// ---------------------------------------------------------------------
+
+// newTLSConfig creates the TLS config of the options.
+func newTLSConfig(options Options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if options.InsecureSkipVerify {
+		warnInsecureSkipVerifyOnce.Do(func() {
+			log.Warn("TLS certificate verification is disabled by insecure_skip_verify")
+		})
+
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if options.CABundlePath != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		pemBytes, err := os.ReadFile(options.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle %v\n\t%w", options.CABundlePath, err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %v", options.CABundlePath)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if options.ClientCertPath != "" || options.ClientKeyPath != "" {
+		if options.ClientCertPath == "" || options.ClientKeyPath == "" {
+			return nil, fmt.Errorf("client certificate and key must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(options.ClientCertPath, options.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate %v\n\t%w", options.ClientCertPath, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
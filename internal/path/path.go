@@ -173,8 +173,13 @@ func (f Path) IsEmpty() bool {
 
 // Join joins two paths.
 func (f Path) Join(other Path) Path {
+	// Copy the path items to avoid sharing the underlying array with f.
+	pathItems := make([]string, 0, len(f.pathItems)+len(other.pathItems))
+	pathItems = append(pathItems, f.pathItems...)
+	pathItems = append(pathItems, other.pathItems...)
+
 	return Path{
-		pathItems: append(f.pathItems, other.pathItems...),
+		pathItems: pathItems,
 	}
 }
 
@@ -0,0 +1,64 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lippkg/lip/internal/context"
+	log "github.com/sirupsen/logrus"
+)
+
+// stdinReader is shared by all prompts, so that input buffered by one prompt is
+// not lost to the next.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// Confirm asks a yes or no question. An empty answer means defaultAnswer. If lip
+// may not ask for input, it fails with a message naming flag, the option that
+// answers the question.
+func Confirm(ctx *context.Context, question string, defaultAnswer bool, flag string) (bool, error) {
+	choices := "[y/N]"
+	if defaultAnswer {
+		choices = "[Y/n]"
+	}
+
+	for {
+		ans, err := Ask(ctx, fmt.Sprintf("%v %v", question, choices), flag)
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(ans) {
+		case "":
+			return defaultAnswer, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+
+		log.Info("Please answer y or n.")
+	}
+}
+
+// Ask asks for a line of text. If lip may not ask for input, it fails with a
+// message naming flag, the option that answers the question.
+func Ask(ctx *context.Context, question string, flag string) (string, error) {
+	if !ctx.IsInteractive() {
+		return "", fmt.Errorf(
+			"cannot ask \"%v\" because lip is running non-interactively. Pass %v to answer it", question, flag)
+	}
+
+	log.Info(question)
+
+	line, err := stdinReader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", fmt.Errorf("no answer to \"%v\" because input ended. Pass %v to answer it", question, flag)
+	} else if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read input\n\t%w", err)
+	}
+
+	return strings.TrimSpace(line), nil
+}
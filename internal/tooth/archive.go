@@ -1,17 +1,13 @@
 package tooth
 
 import (
-	"archive/tar"
-	gozip "archive/zip"
-	"compress/gzip"
 	"fmt"
-	"io"
-	"os"
+	"net/url"
 	"runtime"
 	"strings"
 
+	"github.com/lippkg/lip/internal/archive"
 	"github.com/lippkg/lip/internal/path"
-	"github.com/lippkg/lip/internal/zip"
 )
 
 // Archive is an archive containing a tooth.
@@ -19,20 +15,22 @@ type Archive struct {
 	metadata      Metadata
 	filePath      path.Path
 	assetFilePath path.Path
+	// toothJSON is the unmodified tooth.json in the archive.
+	toothJSON []byte
+	// sourceURL is the URL the archive was downloaded from, or nil for local archives.
+	sourceURL *url.URL
 }
 
 // MakeArchive creates a new archive. It will automatically convert metadata to platform-specific.
 func MakeArchive(archiveFilePath path.Path) (Archive, error) {
-	r, err := gozip.OpenReader(archiveFilePath.LocalString())
+	// Tooth archives are always zip archives.
+	r, err := archive.OpenZip(archiveFilePath)
 	if err != nil {
-		return Archive{}, fmt.Errorf("failed to open zip reader %v\n\t%w", archiveFilePath.LocalString(), err)
+		return Archive{}, fmt.Errorf("failed to open archive %v\n\t%w", archiveFilePath.LocalString(), err)
 	}
 	defer r.Close()
 
-	filePaths, err := zip.GetFilePaths(r)
-	if err != nil {
-		return Archive{}, fmt.Errorf("failed to extract file paths from %v\n\t%w", archiveFilePath.LocalString(), err)
-	}
+	filePaths := r.FilePaths()
 
 	filePathRoot := path.ExtractLongestCommonPath(filePaths...)
 
@@ -46,29 +44,11 @@ func MakeArchive(archiveFilePath path.Path) (Archive, error) {
 		filePathRoot = filePathRootDir
 	}
 
-	// Find tooth.json.
-	toothJSONFilePath := filePathRoot.Join(path.MustParse("tooth.json"))
-	var toothJSONFile *gozip.File = nil
-	for _, file := range r.File {
-		if file.Name == toothJSONFilePath.String() {
-			toothJSONFile = file
-			break
-		}
-	}
-	if toothJSONFile == nil {
-		return Archive{}, fmt.Errorf("archive does not contain tooth.json")
-	}
-
 	// Read tooth.json.
-	toothJSONFileReader, err := toothJSONFile.Open()
-	if err != nil {
-		return Archive{}, fmt.Errorf("failed to open tooth.json\n\t%w", err)
-	}
-	defer toothJSONFileReader.Close()
-
-	toothJSONBytes, err := io.ReadAll(toothJSONFileReader)
+	toothJSONFilePath := filePathRoot.Join(path.MustParse("tooth.json"))
+	toothJSONBytes, err := r.ReadFile(toothJSONFilePath)
 	if err != nil {
-		return Archive{}, fmt.Errorf("failed to read tooth.json\n\t%w", err)
+		return Archive{}, fmt.Errorf("archive does not contain tooth.json\n\t%w", err)
 	}
 
 	// Parse tooth.json.
@@ -95,6 +75,7 @@ func MakeArchive(archiveFilePath path.Path) (Archive, error) {
 		metadata:      metadata,
 		filePath:      archiveFilePath,
 		assetFilePath: path.MakeEmpty(),
+		toothJSON:     toothJSONBytes,
 	}, nil
 }
 
@@ -116,6 +97,24 @@ func (ar Archive) Metadata() Metadata {
 	return ar.metadata
 }
 
+// SourceURL returns the URL the archive was downloaded from, or nil for local
+// archives.
+func (ar Archive) SourceURL() *url.URL {
+	return ar.sourceURL
+}
+
+// ToothJSON returns the unmodified tooth.json in the archive.
+func (ar Archive) ToothJSON() []byte {
+	return ar.toothJSON
+}
+
+// ToSourceURLAttached returns the archive with the URL it was downloaded from.
+func (ar Archive) ToSourceURLAttached(sourceURL *url.URL) Archive {
+	newArchive := ar
+	newArchive.sourceURL = sourceURL
+	return newArchive
+}
+
 // ToAssetArchiveAttached converts the archive to an archive with asset archive attached.
 // If assetArchivePath is empty, the tooth archive will be used as the asset archive.
 func (ar Archive) ToAssetArchiveAttached(assetArchiveFilePath path.Path) (Archive, error) {
@@ -130,49 +129,13 @@ func (ar Archive) ToAssetArchiveAttached(assetArchiveFilePath path.Path) (Archiv
 		return Archive{}, fmt.Errorf("asset archive file path and asset URL must be both specified or both empty")
 	}
 
-	var filePaths []path.Path
 	if assetArchiveFilePath.IsEmpty() {
 		// Extract common prefix and prepend it to all file paths in file.place.
-		if strings.HasSuffix(ar.filePath.LocalString(), ".zip") {
-			r, err := gozip.OpenReader(ar.filePath.LocalString())
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open zip reader %v\n\t%w", ar.filePath.LocalString(), err)
-			}
-			defer r.Close()
-
-			filePaths, err = zip.GetFilePaths(r)
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to extract file paths from %v\n\t%w", ar.filePath.LocalString(), err)
-			}
-
-		} else if strings.HasSuffix(ar.filePath.LocalString(), ".tar.gz") {
-			file, err := os.Open(ar.filePath.LocalString())
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open file %v\n\t%w", ar.filePath.LocalString(), err)
-			}
-			gzr, err := gzip.NewReader(file)
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open gzip reader %v\n\t%w", ar.filePath.LocalString(), err)
-			}
-			defer gzr.Close()
-			r := tar.NewReader(gzr)
-
-			for f, err := r.Next(); err != io.EOF; f, err = r.Next() {
-				if err != nil {
-					return Archive{}, fmt.Errorf("failed to read file paths from %v\n\t%w", ar.filePath.LocalString(), err)
-				}
-				// Skip directories.
-				if f.Typeflag == tar.TypeDir {
-					continue
-				}
-				filePath, err := path.Parse(f.Name)
-				if err != nil {
-					return Archive{}, fmt.Errorf("failed to parse file paths from %v\n\t%w", ar.filePath.LocalString(), err)
-				}
-				filePaths = append(filePaths, filePath)
-			}
-
+		filePaths, err := getArchiveFilePaths(ar.filePath, "")
+		if err != nil {
+			return Archive{}, fmt.Errorf("failed to extract file paths from %v\n\t%w", ar.filePath.LocalString(), err)
 		}
+
 		filePathRoot := path.ExtractLongestCommonPath(filePaths...)
 
 		newMetadata := ar.metadata
@@ -182,52 +145,16 @@ func (ar Archive) ToAssetArchiveAttached(assetArchiveFilePath path.Path) (Archiv
 			return Archive{}, fmt.Errorf("failed to populate wildcards\n\t%w", err)
 		}
 
-		return Archive{
-			metadata:      newMetadataWildcardPopulated,
-			filePath:      ar.filePath,
-			assetFilePath: ar.filePath,
-		}, nil
-	} else {
-		var filePaths []path.Path
-		if strings.HasSuffix(assetArchiveFilePath.LocalString(), ".zip") {
-			r, err := gozip.OpenReader(assetArchiveFilePath.LocalString())
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open zip reader %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-			}
-			defer r.Close()
-
-			filePaths, err = zip.GetFilePaths(r)
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to extract file paths from %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-			}
-
-		} else if strings.HasSuffix(assetArchiveFilePath.LocalString(), ".tar.gz") {
-			file, err := os.Open(assetArchiveFilePath.LocalString())
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open file %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-			}
-			gzr, err := gzip.NewReader(file)
-			if err != nil {
-				return Archive{}, fmt.Errorf("failed to open gzip reader %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-			}
-			defer gzr.Close()
-			r := tar.NewReader(gzr)
-
-			for f, err := r.Next(); err != io.EOF; f, err = r.Next() {
-				if err != nil {
-					return Archive{}, fmt.Errorf("failed to read file paths from %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-				}
-				// Skip directories.
-				if f.Typeflag == tar.TypeDir {
-					continue
-				}
-				filePath, err := path.Parse(f.Name)
-				if err != nil {
-					return Archive{}, fmt.Errorf("failed to parse file paths from %v\n\t%w", assetArchiveFilePath.LocalString(), err)
-				}
-				filePaths = append(filePaths, filePath)
-			}
+		newArchive := ar
+		newArchive.metadata = newMetadataWildcardPopulated
+		newArchive.assetFilePath = ar.filePath
 
+		return newArchive, nil
+	} else {
+		filePaths, err := getArchiveFilePaths(assetArchiveFilePath, archive.RawFileName(assetURL))
+		if err != nil {
+			return Archive{}, fmt.Errorf("failed to extract file paths from %v\n\t%w",
+				assetArchiveFilePath.LocalString(), err)
 		}
 
 		newMetadata := ar.metadata
@@ -236,10 +163,21 @@ func (ar Archive) ToAssetArchiveAttached(assetArchiveFilePath path.Path) (Archiv
 			return Archive{}, fmt.Errorf("failed to populate wildcards\n\t%w", err)
 		}
 
-		return Archive{
-			metadata:      newMetadataWildcardPopulated,
-			filePath:      ar.filePath,
-			assetFilePath: assetArchiveFilePath,
-		}, nil
+		newArchive := ar
+		newArchive.metadata = newMetadataWildcardPopulated
+		newArchive.assetFilePath = assetArchiveFilePath
+
+		return newArchive, nil
 	}
 }
+
+// getArchiveFilePaths returns the paths of all files in an archive.
+func getArchiveFilePaths(filePath path.Path, rawFileName string) ([]path.Path, error) {
+	r, err := archive.Open(filePath, rawFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return r.FilePaths(), nil
+}
@@ -0,0 +1,521 @@
+package tooth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+)
+
+const stateFormatVersion = 1
+
+// Install reasons.
+const (
+	// InstallReasonExplicit is for teeth specified by the user.
+	InstallReasonExplicit = "explicit"
+	// InstallReasonDependency is for teeth installed as dependencies of others.
+	InstallReasonDependency = "dependency"
+)
+
+// InstalledTooth is the record of an installed tooth.
+type InstalledTooth struct {
+	Metadata Metadata
+	Reason   string
+	// InstalledAt is when the tooth was first installed, and UpdatedAt is when it
+	// was last upgraded or reinstalled.
+	InstalledAt time.Time
+	UpdatedAt   time.Time
+	// Files are the placed destinations, relative to the workspace.
+	Files      []path.Path
+	Provenance Provenance
+}
+
+// Provenance describes where an installed tooth came from. Teeth installed by
+// older versions of lip have empty provenance.
+type Provenance struct {
+	// Specifier is the specifier typed by the user, or empty for dependencies.
+	Specifier string `json:"specifier,omitempty"`
+	// ToothJSON is the unmodified tooth.json in the tooth archive.
+	ToothJSON json.RawMessage `json:"tooth_json,omitempty"`
+	// ToothArchiveURL is the URL of the tooth archive, or empty for local archives.
+	// ToothArchiveSourceURL is the URL it was downloaded from after rewriting.
+	ToothArchiveURL       string `json:"tooth_archive_url,omitempty"`
+	ToothArchiveSourceURL string `json:"tooth_archive_source_url,omitempty"`
+	// ToothArchiveDigest is in the form of sha256:<hex>.
+	ToothArchiveDigest string `json:"tooth_archive_digest,omitempty"`
+	// AssetURL is the URL of the asset, or empty if the tooth archive is the asset.
+	// AssetSourceURL is the URL it was downloaded from after rewriting.
+	AssetURL       string `json:"asset_url,omitempty"`
+	AssetSourceURL string `json:"asset_source_url,omitempty"`
+	// AssetDigest is in the form of sha256:<hex>.
+	AssetDigest string `json:"asset_digest,omitempty"`
+	// LipVersion is the version of lip that installed the tooth.
+	LipVersion string `json:"lip_version,omitempty"`
+}
+
+// InstalledStore indexes the records of installed teeth by tooth repository path.
+// It is loaded once per command from the state file of the workspace, and updates
+// are written through to the state file atomically.
+type InstalledStore struct {
+	stateFilePath path.Path
+	records       map[string]rawInstalledTooth
+	teeth         map[string]InstalledTooth
+
+	// journalDir is the directory of recorded transactions, and transaction is the
+	// current transaction, or nil if changes are not recorded.
+	journalDir  path.Path
+	transaction *Transaction
+}
+
+type rawState struct {
+	Version int                          `json:"version"`
+	Teeth   map[string]rawInstalledTooth `json:"teeth"`
+}
+
+type rawInstalledTooth struct {
+	// Metadata is the platform-specific tooth.json of format version 2.
+	Metadata    json.RawMessage `json:"metadata"`
+	Reason      string          `json:"reason"`
+	InstalledAt time.Time       `json:"installed_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Files       []string        `json:"files"`
+	Provenance  Provenance      `json:"provenance"`
+}
+
+// LoadInstalledStore loads the records of the teeth installed in the workspace.
+// Metadata files of older versions of lip are migrated into the state file once,
+// under the workspace lock. The lock is acquired here unless it is already held.
+func LoadInstalledStore(ctx *context.Context) (*InstalledStore, error) {
+	stateFilePath, err := ctx.StateFilePath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get state file path\n\t%w", err)
+	}
+
+	metadataDir, err := ctx.MetadataDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata directory\n\t%w", err)
+	}
+
+	journalDir, err := ctx.JournalDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal directory\n\t%w", err)
+	}
+
+	s := &InstalledStore{
+		stateFilePath: stateFilePath,
+		records:       make(map[string]rawInstalledTooth),
+		teeth:         make(map[string]InstalledTooth),
+		journalDir:    journalDir,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.migrate(ctx, metadataDir); err != nil {
+		return nil, fmt.Errorf("failed to migrate metadata files\n\t%w", err)
+	}
+
+	return s, nil
+}
+
+// ListInstalledInWorkspace lists the metadata of the teeth installed in another
+// workspace without modifying it.
+func ListInstalledInWorkspace(workspaceDir path.Path) ([]Metadata, error) {
+	s := &InstalledStore{
+		stateFilePath: workspaceDir.Join(path.MustParse(".lip/state.json")),
+		records:       make(map[string]rawInstalledTooth),
+		teeth:         make(map[string]InstalledTooth),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	metadataList := s.All()
+
+	// Workspaces not used since the state file was introduced have only metadata
+	// files.
+	legacyMetadataList, _, err := readLegacyMetadataDir(workspaceDir.Join(path.MustParse(".lip/metadata")))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, metadata := range legacyMetadataList {
+		if !s.IsInstalled(metadata.ToothRepoPath()) {
+			metadataList = append(metadataList, metadata)
+		}
+	}
+
+	return metadataList, nil
+}
+
+// All returns the metadata of all installed teeth, sorted by tooth repository path.
+func (s *InstalledStore) All() []Metadata {
+	metadataList := make([]Metadata, 0, len(s.teeth))
+	for _, installedTooth := range s.teeth {
+		metadataList = append(metadataList, installedTooth.Metadata)
+	}
+
+	sort.Slice(metadataList, func(i, j int) bool {
+		return metadataList[i].ToothRepoPath() < metadataList[j].ToothRepoPath()
+	})
+
+	return metadataList
+}
+
+// Get returns the metadata of an installed tooth.
+func (s *InstalledStore) Get(toothRepoPath string) (Metadata, error) {
+	installedTooth, ok := s.teeth[toothRepoPath]
+	if !ok {
+		return Metadata{}, fmt.Errorf("cannot find installed tooth metadata: %v", toothRepoPath)
+	}
+
+	return installedTooth.Metadata, nil
+}
+
+// IsInstalled checks if a tooth is installed.
+func (s *InstalledStore) IsInstalled(toothRepoPath string) bool {
+	_, ok := s.teeth[toothRepoPath]
+	return ok
+}
+
+// Lookup returns the record of an installed tooth.
+func (s *InstalledStore) Lookup(toothRepoPath string) (InstalledTooth, bool) {
+	installedTooth, ok := s.teeth[toothRepoPath]
+	return installedTooth, ok
+}
+
+// Put records a tooth as installed, replacing its previous record if any.
+func (s *InstalledStore) Put(installedTooth InstalledTooth) error {
+	record, err := makeRawInstalledTooth(installedTooth)
+	if err != nil {
+		return err
+	}
+
+	toothRepoPath := installedTooth.Metadata.ToothRepoPath()
+
+	previousRecord, hadPrevious := s.records[toothRepoPath]
+	s.records[toothRepoPath] = record
+
+	if err := s.save(); err != nil {
+		if hadPrevious {
+			s.records[toothRepoPath] = previousRecord
+		} else {
+			delete(s.records, toothRepoPath)
+		}
+		return err
+	}
+
+	previous, hadPreviousTooth := s.teeth[toothRepoPath]
+	s.teeth[toothRepoPath] = installedTooth
+
+	if hadPreviousTooth {
+		s.recordChange(toothRepoPath, &previous, &installedTooth)
+	} else {
+		s.recordChange(toothRepoPath, nil, &installedTooth)
+	}
+
+	return nil
+}
+
+// Remove records a tooth as not installed.
+func (s *InstalledStore) Remove(toothRepoPath string) error {
+	previousRecord, hadPrevious := s.records[toothRepoPath]
+	if !hadPrevious {
+		return nil
+	}
+
+	delete(s.records, toothRepoPath)
+
+	if err := s.save(); err != nil {
+		s.records[toothRepoPath] = previousRecord
+		return err
+	}
+
+	previous, hadPreviousTooth := s.teeth[toothRepoPath]
+	delete(s.teeth, toothRepoPath)
+
+	if hadPreviousTooth {
+		s.recordChange(toothRepoPath, &previous, nil)
+	}
+
+	return nil
+}
+
+// ---------------------------------------------------------------------
+
+// load reads the state file. A missing state file has no teeth. Records that
+// cannot be parsed are skipped with a warning and kept in the state file as they
+// are, so that one corrupt record does not affect the others.
+func (s *InstalledStore) load() error {
+	stateBytes, err := os.ReadFile(s.stateFilePath.LocalString())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read state file %v\n\t%w", s.stateFilePath.LocalString(), err)
+	}
+
+	var state rawState
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return fmt.Errorf("failed to parse state file %v\n\t%w", s.stateFilePath.LocalString(), err)
+	}
+
+	// Newer format versions are migrated here when introduced.
+	if state.Version != stateFormatVersion {
+		return fmt.Errorf("unsupported state file version %v", state.Version)
+	}
+
+	for toothRepoPath, record := range state.Teeth {
+		s.records[toothRepoPath] = record
+
+		installedTooth, err := makeInstalledTooth(record)
+		if err != nil {
+			log.Warnf("Skipped corrupt record of tooth %v in %v\n\t%v", toothRepoPath,
+				s.stateFilePath.LocalString(), err)
+			continue
+		}
+
+		if installedTooth.Metadata.ToothRepoPath() != toothRepoPath {
+			log.Warnf("Skipped record of tooth %v in %v as it describes %v", toothRepoPath,
+				s.stateFilePath.LocalString(), installedTooth.Metadata.ToothRepoPath())
+			continue
+		}
+
+		s.teeth[toothRepoPath] = installedTooth
+	}
+
+	return nil
+}
+
+// save writes the state file atomically.
+func (s *InstalledStore) save() error {
+	stateBytes, err := json.MarshalIndent(rawState{
+		Version: stateFormatVersion,
+		Teeth:   s.records,
+	}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state file\n\t%w", err)
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(s.stateFilePath.LocalString()), "state-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file\n\t%w", err)
+	}
+
+	_, err = tempFile.Write(stateBytes)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write state file\n\t%w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), s.stateFilePath.LocalString()); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to save state file\n\t%w", err)
+	}
+
+	return nil
+}
+
+// migrate adds the metadata files of older versions of lip to the records,
+// migrating their format, saves the state file and deletes the migrated files.
+// Files that cannot be parsed are left in place.
+func (s *InstalledStore) migrate(ctx *context.Context, metadataDir path.Path) error {
+	metadataList, filePaths, err := readLegacyMetadataDir(metadataDir)
+	if err != nil {
+		return err
+	}
+
+	if len(metadataList) == 0 {
+		return nil
+	}
+
+	if !ctx.HoldsWorkspaceLock() {
+		workspaceLock, err := ctx.LockWorkspace()
+		if err != nil {
+			return err
+		}
+		defer workspaceLock.Release()
+
+		// Another command may have migrated the files or changed the state file
+		// before the lock was acquired.
+		s.records = make(map[string]rawInstalledTooth)
+		s.teeth = make(map[string]InstalledTooth)
+
+		if err := s.load(); err != nil {
+			return err
+		}
+
+		metadataList, filePaths, err = readLegacyMetadataDir(metadataDir)
+		if err != nil {
+			return err
+		}
+
+		if len(metadataList) == 0 {
+			return nil
+		}
+	}
+
+	for i, metadata := range metadataList {
+		if s.IsInstalled(metadata.ToothRepoPath()) {
+			continue
+		}
+
+		installedTooth := InstalledTooth{
+			Metadata: metadata,
+			// Teeth installed by older versions of lip have unknown reasons. Treating
+			// them as explicit keeps them from being removed as unneeded.
+			Reason: InstallReasonExplicit,
+		}
+
+		// The metadata file was written on install.
+		if fileInfo, err := os.Stat(filePaths[i].LocalString()); err == nil {
+			installedTooth.InstalledAt = fileInfo.ModTime()
+			installedTooth.UpdatedAt = fileInfo.ModTime()
+		}
+
+		files, err := metadata.Files()
+		if err == nil {
+			for _, place := range files.Place {
+				installedTooth.Files = append(installedTooth.Files, place.Dest)
+			}
+		}
+
+		record, err := makeRawInstalledTooth(installedTooth)
+		if err != nil {
+			return err
+		}
+
+		s.records[metadata.ToothRepoPath()] = record
+		s.teeth[metadata.ToothRepoPath()] = installedTooth
+	}
+
+	if err := s.save(); err != nil {
+		return err
+	}
+
+	return removeLegacyFiles(metadataDir, filePaths)
+}
+
+// removeLegacyFiles deletes the metadata files migrated into the saved state file.
+func removeLegacyFiles(metadataDir path.Path, filePaths []path.Path) error {
+	debugLogger := log.WithFields(log.Fields{
+		"package": "tooth",
+		"method":  "removeLegacyFiles",
+	})
+
+	for _, filePath := range filePaths {
+		if err := os.Remove(filePath.LocalString()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete migrated metadata file\n\t%w", err)
+		}
+
+		debugLogger.Debugf("Migrated metadata file %v", filePath.LocalString())
+	}
+
+	// Remove the directory if nothing is left in it.
+	os.Remove(metadataDir.LocalString())
+
+	return nil
+}
+
+func makeInstalledTooth(record rawInstalledTooth) (InstalledTooth, error) {
+	metadata, err := MakeMetadata(record.Metadata)
+	if err != nil {
+		return InstalledTooth{}, fmt.Errorf("failed to parse metadata\n\t%w", err)
+	}
+
+	files := make([]path.Path, 0, len(record.Files))
+	for _, fileStr := range record.Files {
+		file, err := path.Parse(fileStr)
+		if err != nil {
+			return InstalledTooth{}, fmt.Errorf("failed to parse file path %v\n\t%w", fileStr, err)
+		}
+
+		files = append(files, file)
+	}
+
+	return InstalledTooth{
+		Metadata:    metadata,
+		Reason:      record.Reason,
+		InstalledAt: record.InstalledAt,
+		UpdatedAt:   record.UpdatedAt,
+		Files:       files,
+		Provenance:  record.Provenance,
+	}, nil
+}
+
+func makeRawInstalledTooth(installedTooth InstalledTooth) (rawInstalledTooth, error) {
+	jsonBytes, err := installedTooth.Metadata.MarshalJSON()
+	if err != nil {
+		return rawInstalledTooth{}, fmt.Errorf("failed to marshal metadata\n\t%w", err)
+	}
+
+	files := make([]string, 0, len(installedTooth.Files))
+	for _, file := range installedTooth.Files {
+		files = append(files, file.String())
+	}
+
+	return rawInstalledTooth{
+		Metadata:    jsonBytes,
+		Reason:      installedTooth.Reason,
+		InstalledAt: installedTooth.InstalledAt,
+		UpdatedAt:   installedTooth.UpdatedAt,
+		Files:       files,
+		Provenance:  installedTooth.Provenance,
+	}, nil
+}
+
+// readLegacyMetadataDir reads the metadata files of older versions of lip, named
+// <QueryEscape(tooth)>.json, and returns the metadata with the paths of the files.
+// Files that cannot be parsed are skipped with a warning.
+func readLegacyMetadataDir(metadataDir path.Path) ([]Metadata, []path.Path, error) {
+	metadataList := make([]Metadata, 0)
+	filePaths := make([]path.Path, 0)
+
+	filePathStrings, err := filepath.Glob(filepath.Join(metadataDir.LocalString(), "*.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list metadata files\n\t%w", err)
+	}
+
+	for _, filePathString := range filePathStrings {
+		filePath, err := path.Parse(filePathString)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse metadata file path\n\t%w", err)
+		}
+
+		jsonBytes, err := os.ReadFile(filePath.LocalString())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read metadata file\n\t%w", err)
+		}
+
+		metadata, err := MakeMetadata(jsonBytes)
+		if err != nil {
+			log.Warnf("Skipped corrupt metadata file %v\n\t%v", filePath.LocalString(), err)
+			continue
+		}
+
+		// Check if the metadata file name matches the tooth repo path in the metadata.
+		expectedFileName := fmt.Sprintf("%v.json", url.QueryEscape(metadata.ToothRepoPath()))
+		if filePath.Base() != expectedFileName {
+			log.Warnf("Skipped metadata file %v as its name does not match tooth %v", filePath.LocalString(),
+				metadata.ToothRepoPath())
+			continue
+		}
+
+		metadataList = append(metadataList, metadata)
+		filePaths = append(filePaths, filePath)
+	}
+
+	return metadataList, filePaths, nil
+}
@@ -0,0 +1,289 @@
+package tooth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lippkg/lip/internal/context"
+	"github.com/lippkg/lip/internal/path"
+	log "github.com/sirupsen/logrus"
+)
+
+// Transaction statuses. A transaction left in progress was interrupted.
+const (
+	TransactionInProgress = "in_progress"
+	TransactionCompleted  = "completed"
+	TransactionFailed     = "failed"
+)
+
+// Transaction is a recorded run of a command changing the installed teeth.
+type Transaction struct {
+	ID         int       `json:"id"`
+	Command    string    `json:"command"`
+	Status     string    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Error is the error the transaction failed with.
+	Error string `json:"error,omitempty"`
+	// Before are the teeth installed before the transaction.
+	Before  []TransactionTooth  `json:"before"`
+	Changes []TransactionChange `json:"changes"`
+}
+
+// TransactionTooth is a tooth installed before a transaction.
+type TransactionTooth struct {
+	Tooth   string `json:"tooth"`
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+	// ToothArchiveURL is the URL of the tooth archive, or empty for local archives
+	// and teeth installed by older versions of lip.
+	ToothArchiveURL string `json:"tooth_archive_url,omitempty"`
+}
+
+// TransactionChange is a change of a tooth in a transaction. BeforeVersion is empty
+// for installed teeth, and AfterVersion is empty for uninstalled teeth.
+type TransactionChange struct {
+	Tooth         string   `json:"tooth"`
+	BeforeVersion string   `json:"before_version,omitempty"`
+	AfterVersion  string   `json:"after_version,omitempty"`
+	FilesBefore   []string `json:"files_before,omitempty"`
+	FilesAfter    []string `json:"files_after,omitempty"`
+}
+
+// ListTransactions lists the recorded transactions of the workspace, sorted by ID.
+func ListTransactions(ctx *context.Context) ([]Transaction, error) {
+	journalDir, err := ctx.JournalDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get journal directory\n\t%w", err)
+	}
+
+	ids, err := listTransactionIDs(journalDir)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]Transaction, 0, len(ids))
+	for _, id := range ids {
+		transaction, err := readTransaction(journalDir, id)
+		if err != nil {
+			return nil, err
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, nil
+}
+
+// LoadTransaction loads a recorded transaction of the workspace.
+func LoadTransaction(ctx *context.Context, id int) (Transaction, error) {
+	journalDir, err := ctx.JournalDir()
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to get journal directory\n\t%w", err)
+	}
+
+	if _, err := os.Stat(getTransactionFilePath(journalDir, id).LocalString()); os.IsNotExist(err) {
+		return Transaction{}, fmt.Errorf("transaction %v does not exist", id)
+	}
+
+	return readTransaction(journalDir, id)
+}
+
+// BeginTransaction starts recording the changes to the store as a transaction of
+// command. The transaction is written to the journal on its first change, so runs
+// changing nothing are not recorded.
+func (s *InstalledStore) BeginTransaction(command string) {
+	before := make([]TransactionTooth, 0, len(s.teeth))
+	for _, metadata := range s.All() {
+		installedTooth := s.teeth[metadata.ToothRepoPath()]
+
+		before = append(before, TransactionTooth{
+			Tooth:           metadata.ToothRepoPath(),
+			Version:         metadata.Version().String(),
+			Reason:          installedTooth.Reason,
+			ToothArchiveURL: installedTooth.Provenance.ToothArchiveURL,
+		})
+	}
+
+	s.transaction = &Transaction{
+		Command:   command,
+		Status:    TransactionInProgress,
+		StartedAt: time.Now(),
+		Before:    before,
+		Changes:   make([]TransactionChange, 0),
+	}
+}
+
+// EndTransaction finishes the transaction, recording whether it failed with err.
+func (s *InstalledStore) EndTransaction(err error) {
+	transaction := s.transaction
+	s.transaction = nil
+
+	if transaction == nil || len(transaction.Changes) == 0 {
+		return
+	}
+
+	transaction.FinishedAt = time.Now()
+	if err != nil {
+		transaction.Status = TransactionFailed
+		transaction.Error = err.Error()
+	} else {
+		transaction.Status = TransactionCompleted
+	}
+
+	if err := saveTransaction(s.journalDir, *transaction); err != nil {
+		log.Warnf("Failed to record transaction %v\n\t%v", transaction.ID, err)
+	}
+}
+
+// ---------------------------------------------------------------------
+
+// recordChange records a change of a tooth in the current transaction, if any.
+// Changes of the same tooth are merged, so that an upgrade is one change. after is
+// nil for uninstalled teeth.
+func (s *InstalledStore) recordChange(toothRepoPath string, before *InstalledTooth, after *InstalledTooth) {
+	if s.transaction == nil {
+		return
+	}
+
+	if s.transaction.ID == 0 {
+		id, err := getNextTransactionID(s.journalDir)
+		if err != nil {
+			log.Warnf("Failed to record transaction\n\t%v", err)
+			return
+		}
+
+		s.transaction.ID = id
+	}
+
+	var change *TransactionChange
+	for i := range s.transaction.Changes {
+		if s.transaction.Changes[i].Tooth == toothRepoPath {
+			change = &s.transaction.Changes[i]
+			break
+		}
+	}
+
+	if change == nil {
+		s.transaction.Changes = append(s.transaction.Changes, TransactionChange{Tooth: toothRepoPath})
+		change = &s.transaction.Changes[len(s.transaction.Changes)-1]
+
+		if before != nil {
+			change.BeforeVersion = before.Metadata.Version().String()
+			change.FilesBefore = getFileStrings(before.Files)
+		}
+	}
+
+	change.AfterVersion = ""
+	change.FilesAfter = nil
+	if after != nil {
+		change.AfterVersion = after.Metadata.Version().String()
+		change.FilesAfter = getFileStrings(after.Files)
+	}
+
+	if err := saveTransaction(s.journalDir, *s.transaction); err != nil {
+		log.Warnf("Failed to record transaction %v\n\t%v", s.transaction.ID, err)
+	}
+}
+
+func getFileStrings(files []path.Path) []string {
+	fileStrings := make([]string, 0, len(files))
+	for _, file := range files {
+		fileStrings = append(fileStrings, file.String())
+	}
+
+	return fileStrings
+}
+
+func getNextTransactionID(journalDir path.Path) (int, error) {
+	ids, err := listTransactionIDs(journalDir)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 1, nil
+	}
+
+	return ids[len(ids)-1] + 1, nil
+}
+
+func getTransactionFilePath(journalDir path.Path, id int) path.Path {
+	return journalDir.Join(path.MustParse(fmt.Sprintf("%v.json", id)))
+}
+
+// listTransactionIDs lists the IDs of the transactions in the journal in
+// ascending order.
+func listTransactionIDs(journalDir path.Path) ([]int, error) {
+	filePathStrings, err := filepath.Glob(filepath.Join(journalDir.LocalString(), "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list journal files\n\t%w", err)
+	}
+
+	ids := make([]int, 0, len(filePathStrings))
+	for _, filePathString := range filePathStrings {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(filePathString), ".json"))
+		if err != nil || id <= 0 {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+func readTransaction(journalDir path.Path, id int) (Transaction, error) {
+	filePath := getTransactionFilePath(journalDir, id)
+
+	jsonBytes, err := os.ReadFile(filePath.LocalString())
+	if err != nil {
+		return Transaction{}, fmt.Errorf("failed to read journal file %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	var transaction Transaction
+	if err := json.Unmarshal(jsonBytes, &transaction); err != nil {
+		return Transaction{}, fmt.Errorf("failed to parse journal file %v\n\t%w", filePath.LocalString(), err)
+	}
+
+	return transaction, nil
+}
+
+// saveTransaction writes a transaction to the journal atomically.
+func saveTransaction(journalDir path.Path, transaction Transaction) error {
+	if err := os.MkdirAll(journalDir.LocalString(), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory\n\t%w", err)
+	}
+
+	jsonBytes, err := json.MarshalIndent(transaction, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transaction\n\t%w", err)
+	}
+
+	tempFile, err := os.CreateTemp(journalDir.LocalString(), "transaction-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file\n\t%w", err)
+	}
+
+	_, err = tempFile.Write(jsonBytes)
+	tempFile.Close()
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to write journal file\n\t%w", err)
+	}
+
+	if err := os.Rename(tempFile.Name(), getTransactionFilePath(journalDir, transaction.ID).LocalString()); err != nil {
+		os.Remove(tempFile.Name())
+		return fmt.Errorf("failed to save journal file\n\t%w", err)
+	}
+
+	return nil
+}
@@ -53,25 +53,25 @@ const metadataJSONSchema = `{
 				"pre_install": {
 					"type": "array",
 					"items": {
-						"type": "string"
+						"$ref": "#/definitions/command"
 					}
 				},
 				"post_install": {
 					"type": "array",
 					"items": {
-						"type": "string"
+						"$ref": "#/definitions/command"
 					}
 				},
 				"pre_uninstall": {
 					"type": "array",
 					"items": {
-						"type": "string"
+						"$ref": "#/definitions/command"
 					}
 				},
 				"post_uninstall": {
 					"type": "array",
 					"items": {
-						"type": "string"
+						"$ref": "#/definitions/command"
 					}
 				}
 			}
@@ -127,6 +127,15 @@ const metadataJSONSchema = `{
 				}
 			}
 		},
+		"bin": {
+			"type": "object",
+			"patternProperties": {
+				"^[A-Za-z0-9_][A-Za-z0-9._-]*$": {
+					"type": "string"
+				}
+			},
+			"additionalProperties": false
+		},
 		"platforms": {
 			"type": "array",
 			"items": {
@@ -147,25 +156,25 @@ const metadataJSONSchema = `{
 							"pre_install": {
 								"type": "array",
 								"items": {
-									"type": "string"
+									"$ref": "#/definitions/command"
 								}
 							},
 							"post_install": {
 								"type": "array",
 								"items": {
-									"type": "string"
+									"$ref": "#/definitions/command"
 								}
 							},
 							"pre_uninstall": {
 								"type": "array",
 								"items": {
-									"type": "string"
+									"$ref": "#/definitions/command"
 								}
 							},
 							"post_uninstall": {
 								"type": "array",
 								"items": {
-									"type": "string"
+									"$ref": "#/definitions/command"
 								}
 							}
 						}
@@ -186,6 +195,15 @@ const metadataJSONSchema = `{
 							}
 						}
 					},
+					"bin": {
+						"type": "object",
+						"patternProperties": {
+							"^[A-Za-z0-9_][A-Za-z0-9._-]*$": {
+								"type": "string"
+							}
+						},
+						"additionalProperties": false
+					},
 					"files": {
 						"type": "object",
 						"properties": {
@@ -227,5 +245,69 @@ const metadataJSONSchema = `{
 		"tooth",
 		"version",
 		"info"
-	]
+	],
+	"definitions": {
+		"command": {
+			"oneOf": [
+				{
+					"type": "string"
+				},
+				{
+					"$ref": "#/definitions/action"
+				}
+			]
+		},
+		"action": {
+			"type": "object",
+			"properties": {
+				"action": {
+					"type": "string",
+					"enum": [
+						"copy",
+						"move",
+						"mkdir",
+						"delete",
+						"chmod",
+						"symlink",
+						"extract-archive",
+						"edit-json",
+						"replace-in-file"
+					]
+				},
+				"src": {
+					"type": "string"
+				},
+				"dest": {
+					"type": "string"
+				},
+				"path": {
+					"type": "string"
+				},
+				"mode": {
+					"type": "string",
+					"pattern": "^[0-7]{3,4}$"
+				},
+				"key": {
+					"type": "string"
+				},
+				"value": {},
+				"operation": {
+					"type": "string",
+					"enum": [
+						"set",
+						"merge"
+					]
+				},
+				"search": {
+					"type": "string"
+				},
+				"replace": {
+					"type": "string"
+				}
+			},
+			"required": [
+				"action"
+			]
+		}
+	}
 }`
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
 
 	gopath "path"
@@ -27,12 +28,36 @@ type Info struct {
 	Tags        []string
 }
 type Commands struct {
-	PreInstall    []string
-	PostInstall   []string
-	PreUninstall  []string
-	PostUninstall []string
+	PreInstall    []CommandsItem
+	PostInstall   []CommandsItem
+	PreUninstall  []CommandsItem
+	PostUninstall []CommandsItem
 }
 
+// CommandsItem is either a shell command or a declarative action.
+type CommandsItem struct {
+	// Command is the shell command. It is empty if the item is an action.
+	Command string
+	// Action is the declarative action. It is nil if the item is a shell command.
+	Action *Action
+}
+
+// Action is a declarative action run natively by lip.
+type Action RawMetadataAction
+
+// Action types.
+const (
+	ActionCopy           = "copy"
+	ActionMove           = "move"
+	ActionMkdir          = "mkdir"
+	ActionDelete         = "delete"
+	ActionChmod          = "chmod"
+	ActionSymlink        = "symlink"
+	ActionExtractArchive = "extract-archive"
+	ActionEditJSON       = "edit-json"
+	ActionReplaceInFile  = "replace-in-file"
+)
+
 type Files struct {
 	Place    []FilesPlaceItem
 	Preserve []path.Path
@@ -126,6 +151,43 @@ func MakeMetadataFromRaw(rawMetadata RawMetadata) (Metadata, error) {
 		return Metadata{}, fmt.Errorf("failed to parse version\n\t%w", err)
 	}
 
+	allCommands := []RawMetadataCommands{rawMetadata.Commands}
+	for _, platformItem := range rawMetadata.Platforms {
+		allCommands = append(allCommands, platformItem.Commands)
+	}
+
+	for _, commands := range allCommands {
+		for _, items := range [][]RawMetadataCommandsItem{commands.PreInstall, commands.PostInstall,
+			commands.PreUninstall, commands.PostUninstall} {
+			for _, item := range items {
+				if item.Action == nil {
+					continue
+				}
+
+				if err := validateAction(*item.Action); err != nil {
+					return Metadata{}, fmt.Errorf("invalid action %v\n\t%w", item.Action.Action, err)
+				}
+			}
+		}
+	}
+
+	allBin := []map[string]string{rawMetadata.Bin}
+	for _, platformItem := range rawMetadata.Platforms {
+		allBin = append(allBin, platformItem.Bin)
+	}
+
+	for _, bin := range allBin {
+		for name, target := range bin {
+			if !binNameRegexp.MatchString(name) {
+				return Metadata{}, fmt.Errorf("invalid executable name %v", name)
+			}
+
+			if target == "" {
+				return Metadata{}, fmt.Errorf("missing path of executable %v", name)
+			}
+		}
+	}
+
 	return Metadata{rawMetadata}, nil
 }
 
@@ -146,7 +208,25 @@ func (m Metadata) AssetURL() (*url.URL, error) {
 }
 
 func (m Metadata) Commands() Commands {
-	return Commands(m.rawMetadata.Commands)
+	convert := func(rawItems []RawMetadataCommandsItem) []CommandsItem {
+		items := make([]CommandsItem, 0, len(rawItems))
+		for _, rawItem := range rawItems {
+			item := CommandsItem{Command: rawItem.Command}
+			if rawItem.Action != nil {
+				action := Action(*rawItem.Action)
+				item.Action = &action
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+
+	return Commands{
+		PreInstall:    convert(m.rawMetadata.Commands.PreInstall),
+		PostInstall:   convert(m.rawMetadata.Commands.PostInstall),
+		PreUninstall:  convert(m.rawMetadata.Commands.PreUninstall),
+		PostUninstall: convert(m.rawMetadata.Commands.PostUninstall),
+	}
 }
 
 func (m Metadata) Dependencies() (map[string]semver.Range, error) {
@@ -249,6 +329,26 @@ func (m Metadata) Files() (Files, error) {
 	}, nil
 }
 
+// Bin returns the executables of the tooth by name. Their paths are relative to
+// the workspace. Names are checked again, as they become file names of shims.
+func (m Metadata) Bin() (map[string]path.Path, error) {
+	bin := make(map[string]path.Path)
+	for name, target := range m.rawMetadata.Bin {
+		if !binNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid executable name %v", name)
+		}
+
+		targetPath, err := path.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse path of executable %v\n\t%w", name, err)
+		}
+
+		bin[name] = targetPath
+	}
+
+	return bin, nil
+}
+
 func (m Metadata) IsWildcardPopulated() bool {
 	for _, placeItem := range m.rawMetadata.Files.Place {
 		if strings.Contains(placeItem.Src, "*") {
@@ -278,6 +378,11 @@ func (m Metadata) ToPlatformSpecific(goos string, goarch string) (Metadata, erro
 	if raw.Prerequisites == nil {
 		raw.Prerequisites = make(map[string]string)
 	}
+	// Copy bin, as platforms override its entries.
+	raw.Bin = make(map[string]string)
+	for name, target := range m.rawMetadata.Bin {
+		raw.Bin[name] = target
+	}
 	raw.Platforms = nil
 
 	for _, platformItem := range m.rawMetadata.Platforms {
@@ -309,6 +414,10 @@ func (m Metadata) ToPlatformSpecific(goos string, goarch string) (Metadata, erro
 		raw.Files.Place = append(raw.Files.Place, platformItem.Files.Place...)
 		raw.Files.Preserve = append(raw.Files.Preserve, platformItem.Files.Preserve...)
 		raw.Files.Remove = append(raw.Files.Remove, platformItem.Files.Remove...)
+
+		for name, target := range platformItem.Bin {
+			raw.Bin[name] = target
+		}
 	}
 
 	return MakeMetadataFromRaw(raw)
@@ -355,9 +464,15 @@ func (m Metadata) ToWildcardPopulated(filePaths []path.Path) (Metadata, error) {
 			return Metadata{}, fmt.Errorf("failed to parse source path prefix\n\t%w", err)
 		}
 
-		destPathPrefix, err := path.Parse(placeItem.Dest)
-		if err != nil {
-			return Metadata{}, fmt.Errorf("failed to parse destination path prefix\n\t%w", err)
+		// The destination may start with a reference to a root, expanded on install.
+		rootReference, destStr := splitRootReference(placeItem.Dest)
+
+		destPathPrefix := path.MakeEmpty()
+		if destStr != "" {
+			destPathPrefix, err = path.Parse(destStr)
+			if err != nil {
+				return Metadata{}, fmt.Errorf("failed to parse destination path prefix\n\t%w", err)
+			}
 		}
 
 		for _, filePath := range filePaths {
@@ -369,7 +484,7 @@ func (m Metadata) ToWildcardPopulated(filePaths []path.Path) (Metadata, error) {
 
 			newPlace = append(newPlace, RawMetadataFilesPlaceItem{
 				Src:  filePath.String(),
-				Dest: destPathPrefix.Join(relFilePath).String(),
+				Dest: joinRootReference(rootReference, destPathPrefix.Join(relFilePath).String()),
 			})
 
 			debugLogger.Debugf("Populated %v to %v", filePath, destPathPrefix.Join(relFilePath))
@@ -383,6 +498,162 @@ func (m Metadata) ToWildcardPopulated(filePaths []path.Path) (Metadata, error) {
 	return newMetadata, nil
 }
 
+// ToRootsExpanded replaces references to named roots at the start of files.place
+// destinations, files.preserve and files.remove paths and bin paths, e.g. in
+// $(root:plugins)/foo.dll, with the directories of the roots relative to the
+// workspace. It fails if a root is not in roots.
+func (m Metadata) ToRootsExpanded(roots map[string]path.Path) (Metadata, error) {
+	expand := func(pathStr string) (string, error) {
+		rootReference, rest := splitRootReference(pathStr)
+		if strings.Contains(rest, "$(") {
+			return "", fmt.Errorf("%v references a root not at its start", pathStr)
+		}
+
+		if rootReference == "" {
+			return pathStr, nil
+		}
+
+		name := rootReferenceRegexp.FindStringSubmatch(rootReference)[1]
+
+		rootDir, ok := roots[name]
+		if !ok {
+			return "", fmt.Errorf(
+				"tooth %v references root %v, which the workspace does not define. Define it in the roots config key",
+				m.ToothRepoPath(), name)
+		}
+
+		return joinRootReference(rootDir.String(), rest), nil
+	}
+
+	newRaw := m.rawMetadata
+
+	newRaw.Files.Place = make([]RawMetadataFilesPlaceItem, 0, len(m.rawMetadata.Files.Place))
+	for _, placeItem := range m.rawMetadata.Files.Place {
+		dest, err := expand(placeItem.Dest)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		newRaw.Files.Place = append(newRaw.Files.Place, RawMetadataFilesPlaceItem{
+			Src:  placeItem.Src,
+			Dest: dest,
+		})
+	}
+
+	newRaw.Files.Preserve = make([]string, 0, len(m.rawMetadata.Files.Preserve))
+	for _, preserveItem := range m.rawMetadata.Files.Preserve {
+		preservePath, err := expand(preserveItem)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		newRaw.Files.Preserve = append(newRaw.Files.Preserve, preservePath)
+	}
+
+	newRaw.Files.Remove = make([]string, 0, len(m.rawMetadata.Files.Remove))
+	for _, removeItem := range m.rawMetadata.Files.Remove {
+		removePath, err := expand(removeItem)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		newRaw.Files.Remove = append(newRaw.Files.Remove, removePath)
+	}
+
+	if m.rawMetadata.Bin != nil {
+		newRaw.Bin = make(map[string]string, len(m.rawMetadata.Bin))
+		for name, target := range m.rawMetadata.Bin {
+			targetPath, err := expand(target)
+			if err != nil {
+				return Metadata{}, err
+			}
+
+			newRaw.Bin[name] = targetPath
+		}
+	}
+
+	return Metadata{newRaw}, nil
+}
+
+// binNameRegexp matches the names of executables, which are also the file names
+// of their shims. Names cannot start with a dot, which also excludes . and ..
+var binNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// rootReferenceRegexp matches a reference to a named root at the start of a path.
+var rootReferenceRegexp = regexp.MustCompile(`^\$\(root:([A-Za-z0-9_-]+)\)`)
+
+// joinRootReference joins a root reference or directory with the rest of a path.
+func joinRootReference(root string, rest string) string {
+	if root == "" {
+		return rest
+	}
+
+	if rest == "" {
+		return root
+	}
+
+	return root + "/" + rest
+}
+
+// splitRootReference splits a path into the root reference at its start, if any,
+// and the rest of the path.
+func splitRootReference(pathStr string) (string, string) {
+	rootReference := rootReferenceRegexp.FindString(pathStr)
+	if rootReference == "" {
+		return "", pathStr
+	}
+
+	rest := strings.TrimPrefix(pathStr, rootReference)
+	if rest != "" && !strings.HasPrefix(rest, "/") {
+		return "", pathStr
+	}
+
+	return rootReference, strings.TrimPrefix(rest, "/")
+}
+
+// validateAction checks that an action has the fields its type requires.
+func validateAction(action RawMetadataAction) error {
+	requiredFields := map[string]string{}
+
+	switch action.Action {
+	case ActionCopy, ActionMove, ActionSymlink, ActionExtractArchive:
+		requiredFields["src"] = action.Src
+		requiredFields["dest"] = action.Dest
+
+	case ActionMkdir, ActionDelete:
+		requiredFields["path"] = action.Path
+
+	case ActionChmod:
+		requiredFields["path"] = action.Path
+		requiredFields["mode"] = action.Mode
+
+	case ActionEditJSON:
+		requiredFields["path"] = action.Path
+		requiredFields["key"] = action.Key
+		if len(action.Value) == 0 {
+			return fmt.Errorf("missing value")
+		}
+		if action.Operation != "" && action.Operation != "set" && action.Operation != "merge" {
+			return fmt.Errorf("unknown operation %v", action.Operation)
+		}
+
+	case ActionReplaceInFile:
+		requiredFields["path"] = action.Path
+		requiredFields["search"] = action.Search
+
+	default:
+		return fmt.Errorf("unknown action type %v", action.Action)
+	}
+
+	for field, value := range requiredFields {
+		if value == "" {
+			return fmt.Errorf("missing %v", field)
+		}
+	}
+
+	return nil
+}
+
 func parseFormatVersion(jsonBytes []byte) (int, error) {
 	jsonData := make(map[string]interface{})
 	err := json.Unmarshal(jsonBytes, &jsonData)
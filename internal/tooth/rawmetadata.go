@@ -1,5 +1,10 @@
 package tooth
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // Why to split Metadata and RawMetadata? Because we encounter a problem when
 // we want to add a getter with the same name as a field.
 type RawMetadata struct {
@@ -13,6 +18,7 @@ type RawMetadata struct {
 	Dependencies  map[string]string   `json:"dependencies,omitempty"`
 	Prerequisites map[string]string   `json:"prerequisites,omitempty"`
 	Files         RawMetadataFiles    `json:"files,omitempty"`
+	Bin           map[string]string   `json:"bin,omitempty"`
 
 	Platforms []RawMetadataPlatformsItem `json:"platforms,omitempty"`
 }
@@ -25,10 +31,54 @@ type RawMetadataInfo struct {
 }
 
 type RawMetadataCommands struct {
-	PreInstall    []string `json:"pre_install,omitempty"`
-	PostInstall   []string `json:"post_install,omitempty"`
-	PreUninstall  []string `json:"pre_uninstall,omitempty"`
-	PostUninstall []string `json:"post_uninstall,omitempty"`
+	PreInstall    []RawMetadataCommandsItem `json:"pre_install,omitempty"`
+	PostInstall   []RawMetadataCommandsItem `json:"post_install,omitempty"`
+	PreUninstall  []RawMetadataCommandsItem `json:"pre_uninstall,omitempty"`
+	PostUninstall []RawMetadataCommandsItem `json:"post_uninstall,omitempty"`
+}
+
+// RawMetadataCommandsItem is either a shell command, which is a JSON string, or
+// a declarative action, which is a JSON object.
+type RawMetadataCommandsItem struct {
+	Command string
+	Action  *RawMetadataAction
+}
+
+type RawMetadataAction struct {
+	Action    string          `json:"action"`
+	Src       string          `json:"src,omitempty"`
+	Dest      string          `json:"dest,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Mode      string          `json:"mode,omitempty"`
+	Key       string          `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Operation string          `json:"operation,omitempty"`
+	Search    string          `json:"search,omitempty"`
+	Replace   string          `json:"replace,omitempty"`
+}
+
+func (item RawMetadataCommandsItem) MarshalJSON() ([]byte, error) {
+	if item.Action != nil {
+		return json.Marshal(item.Action)
+	}
+
+	return json.Marshal(item.Command)
+}
+
+func (item *RawMetadataCommandsItem) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*item = RawMetadataCommandsItem{Command: command}
+		return nil
+	}
+
+	var action RawMetadataAction
+	if err := json.Unmarshal(data, &action); err != nil {
+		return fmt.Errorf("command must be a string or an action object\n\t%w", err)
+	}
+
+	*item = RawMetadataCommandsItem{Action: &action}
+	return nil
 }
 
 type RawMetadataFiles struct {
@@ -51,4 +101,5 @@ type RawMetadataPlatformsItem struct {
 	Dependencies  map[string]string   `json:"dependencies,omitempty"`
 	Prerequisites map[string]string   `json:"prerequisites,omitempty"`
 	Files         RawMetadataFiles    `json:"files,omitempty"`
+	Bin           map[string]string   `json:"bin,omitempty"`
 }
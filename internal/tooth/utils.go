@@ -4,61 +4,15 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/blang/semver/v4"
 	"github.com/lippkg/lip/internal/context"
 	"github.com/lippkg/lip/internal/network"
-	"github.com/lippkg/lip/internal/path"
 
 	"golang.org/x/mod/module"
 )
 
-// GetAllMetadata lists all installed tooth metadata.
-func GetAllMetadata(ctx *context.Context) ([]Metadata, error) {
-	metadataList := make([]Metadata, 0)
-
-	metadataDir, err := ctx.MetadataDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata directory\n\t%w", err)
-	}
-
-	filePathStrings, err := filepath.Glob(filepath.Join(metadataDir.LocalString(), "*.json"))
-	if err != nil {
-		return nil, fmt.Errorf("failed to list metadata files\n\t%w", err)
-	}
-
-	for _, filePathString := range filePathStrings {
-		filePath, err := path.Parse(filePathString)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse metadata file path\n\t%w", err)
-		}
-
-		jsonBytes, err := os.ReadFile(filePath.LocalString())
-		if err != nil {
-			return nil, fmt.Errorf("failed to read metadata file\n\t%w", err)
-		}
-
-		metadata, err := MakeMetadata(jsonBytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse metadata file\n\t%w", err)
-		}
-
-		// Check if the metadata file name matches the tooth repo path in the metadata.
-		expectedFileName := fmt.Sprintf("%v.json", url.QueryEscape(metadata.ToothRepoPath()))
-		if filePath.Base() != expectedFileName {
-			return nil, fmt.Errorf("metadata file name does not match: %v", filePath.LocalString())
-		}
-
-		metadataList = append(metadataList, metadata)
-	}
-
-	return metadataList, nil
-}
-
 // GetAvailableVersions fetches the version list of a tooth repository.
 func GetAvailableVersions(ctx *context.Context, toothRepoPath string) (semver.Versions,
 	error) {
@@ -77,12 +31,12 @@ func GetAvailableVersions(ctx *context.Context, toothRepoPath string) (semver.Ve
 		return nil, fmt.Errorf("failed to generate version list URL\n\t%w", err)
 	}
 
-	proxyURL, err := ctx.ProxyURL()
+	networkOptions, err := ctx.NetworkOptions()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get proxy URL\n\t%w", err)
+		return nil, fmt.Errorf("failed to get network options\n\t%w", err)
 	}
 
-	content, err := network.GetContent(versionURL, proxyURL)
+	content, err := network.GetContent(versionURL, networkOptions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch version list\n\t%w", err)
 	}
@@ -156,44 +110,6 @@ func GetLatestVersionInVersionRange(ctx *context.Context,
 	return semver.Version{}, fmt.Errorf("no available version found")
 }
 
-// GetMetadata finds the installed tooth metadata.
-func GetMetadata(ctx *context.Context, toothRepoPath string) (Metadata,
-	error) {
-
-	metadataList, err := GetAllMetadata(ctx)
-	if err != nil {
-		return Metadata{}, fmt.Errorf(
-			"failed to list all installed tooth metadata\n\t%w", err)
-	}
-
-	for _, metadata := range metadataList {
-		if metadata.ToothRepoPath() == toothRepoPath {
-			return metadata, nil
-		}
-	}
-
-	return Metadata{}, fmt.Errorf("cannot find installed tooth metadata: %v",
-		toothRepoPath)
-}
-
-// IsInstalled checks if a tooth is installed.
-func IsInstalled(ctx *context.Context, toothRepoPath string) (bool, error) {
-
-	metadataList, err := GetAllMetadata(ctx)
-	if err != nil {
-		return false, fmt.Errorf(
-			"failed to list all installed tooth metadata\n\t%w", err)
-	}
-
-	for _, metadata := range metadataList {
-		if metadata.ToothRepoPath() == toothRepoPath {
-			return true, nil
-		}
-	}
-
-	return false, nil
-}
-
 // IsValidToothRepoPath checks if the tooth repository path is valid.
 func IsValidToothRepoPath(toothRepoPath string) bool {
 	if err := module.CheckPath(toothRepoPath); err != nil {